@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// adminToken 是管理接口的访问令牌，为空时管理接口不可用
+var adminToken string
+
+var adminTemplates = template.Must(template.New("admin").Parse(adminHTMLTemplate))
+
+// AdminRequest 是管理接口的操作请求
+type AdminRequest struct {
+	Action string `json:"action"` // add、remove、pause、resume 或 reindex
+	Name   string `json:"name"`
+	Path   string `json:"path,omitempty"` // add 时使用
+
+	Options MatchOptions `json:"options"` // add 时使用，新根目录默认的匹配选项
+}
+
+// AdminResponse 是管理接口的响应
+type AdminResponse struct {
+	Roots []RootInfo `json:"roots,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+// checkAdmin 校验 Authorization: Bearer 令牌，失败时写入错误响应
+func checkAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if adminToken == "" {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(AdminResponse{Error: "管理接口未启用，请使用 -admin-token 设置访问令牌"})
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(AdminResponse{Error: "未授权"})
+		return false
+	}
+	return true
+}
+
+// handleAdmin 返回管理页面，页面中的操作通过令牌调用管理接口
+func handleAdmin(w http.ResponseWriter, r *http.Request) {
+	adminTemplates.ExecuteTemplate(w, "admin", nil)
+}
+
+// handleAdminRoots 列出根目录，或执行添加、移除、暂停、恢复和重建索引操作
+func handleAdminRoots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !checkAdmin(w, r) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		json.NewEncoder(w).Encode(AdminResponse{Roots: rootInfos()})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AdminRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	switch req.Action {
+	case "add":
+		_, err = addRoot(RootConfig{Name: req.Name, Path: req.Path, Options: req.Options})
+	case "remove":
+		err = removeRoot(req.Name)
+	case "pause":
+		_, err = pauseRoot(req.Name, true)
+	case "resume":
+		_, err = pauseRoot(req.Name, false)
+	case "reindex":
+		err = reindexRoot(req.Name)
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(AdminResponse{Error: err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(AdminResponse{Roots: rootInfos()})
+}
+
+// reindexRoot 在后台重新完整遍历根目录
+func reindexRoot(name string) error {
+	root := findRoot(name)
+	if root == nil {
+		return fmt.Errorf("未知的根目录 %s", name)
+	}
+	if root.Paused {
+		return errPaused
+	}
+
+	idx := lookupIndex(root.Path)
+	if idx == nil {
+		go buildRootIndex(root)
+		return nil
+	}
+	go func() {
+		<-idx.ready
+		if idx.readyErr != nil {
+			return
+		}
+		if err := idx.rebuild(); err != nil {
+			log.Printf("重建索引失败 %s: %v", name, err)
+		}
+	}()
+	return nil
+}
+
+const adminHTMLTemplate = `
+<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>FZF Web 管理</title>
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #f5f7fa;
+            color: #333;
+        }
+
+        h1 {
+            font-size: 1.6rem;
+        }
+
+        .bar {
+            display: flex;
+            flex-wrap: wrap;
+            gap: 10px;
+            margin-bottom: 15px;
+        }
+
+        input {
+            padding: 8px 10px;
+            border: 1px solid #d0d7de;
+            border-radius: 6px;
+        }
+
+        button {
+            padding: 6px 12px;
+            border: none;
+            border-radius: 6px;
+            background: #4facfe;
+            color: white;
+            cursor: pointer;
+        }
+
+        button.danger {
+            background: #dc3545;
+        }
+
+        table {
+            width: 100%;
+            border-collapse: collapse;
+            background: white;
+        }
+
+        th, td {
+            padding: 8px 10px;
+            border-bottom: 1px solid #e1e5e9;
+            text-align: left;
+            font-size: 0.9rem;
+            vertical-align: top;
+        }
+
+        .error {
+            color: #721c24;
+        }
+    </style>
+</head>
+<body>
+    <h1>根目录管理</h1>
+    <div class="bar">
+        <input type="password" id="tokenInput" placeholder="访问令牌">
+        <button onclick="saveToken()">保存令牌</button>
+        <a href="/">返回搜索</a>
+    </div>
+    <div class="bar">
+        <input type="text" id="nameInput" placeholder="名称">
+        <input type="text" id="pathInput" placeholder="/path/to/dir" size="40">
+        <button onclick="act('add', nameInput.value.trim(), pathInput.value.trim())">添加根目录</button>
+    </div>
+    <div id="error" class="error"></div>
+    <table>
+        <thead>
+            <tr><th>名称</th><th>路径</th><th>状态</th><th>文件数</th><th>构建耗时</th><th>构建时间</th><th>文件监听</th><th>最近错误</th><th>操作</th></tr>
+        </thead>
+        <tbody id="rootsBody"></tbody>
+    </table>
+
+    <script>
+        const tokenInput = document.getElementById('tokenInput');
+        const nameInput = document.getElementById('nameInput');
+        const pathInput = document.getElementById('pathInput');
+        const rootsBody = document.getElementById('rootsBody');
+        const error = document.getElementById('error');
+
+        tokenInput.value = localStorage.getItem('fzfWebAdminToken') || '';
+
+        function saveToken() {
+            localStorage.setItem('fzfWebAdminToken', tokenInput.value);
+            refresh();
+        }
+
+        async function call(method, body) {
+            const response = await fetch('/api/admin/roots', {
+                method: method,
+                headers: {
+                    'Authorization': 'Bearer ' + tokenInput.value,
+                    'Content-Type': 'application/json'
+                },
+                body: body ? JSON.stringify(body) : undefined
+            });
+            const data = await response.json().catch(function() { return null; });
+            if (!data) {
+                throw new Error('HTTP ' + response.status + ': ' + response.statusText);
+            }
+            if (data.error) {
+                throw new Error(data.error);
+            }
+            return data;
+        }
+
+        async function refresh() {
+            try {
+                const data = await call('GET');
+                error.textContent = '';
+                render(data.roots || []);
+            } catch (err) {
+                error.textContent = err.message;
+            }
+        }
+
+        async function act(action, name, path) {
+            if (action === 'remove' && !confirm('移除根目录 ' + name + '？')) {
+                return;
+            }
+            try {
+                const data = await call('POST', { action: action, name: name, path: path });
+                error.textContent = '';
+                render(data.roots || []);
+            } catch (err) {
+                error.textContent = err.message;
+            }
+        }
+
+        function render(roots) {
+            rootsBody.innerHTML = roots.map(function(root) {
+                const idx = root.index;
+                let state = '运行中';
+                if (root.paused) {
+                    state = '已暂停';
+                } else if (!idx || idx.building) {
+                    state = '构建中';
+                }
+                const name = escapeHtml(root.name);
+                const pause = root.paused ? 'resume' : 'pause';
+                const cells = [
+                    name,
+                    escapeHtml(root.path),
+                    state,
+                    idx ? idx.files : '-',
+                    idx && !idx.building ? idx.buildMs + ' ms' : '-',
+                    idx && !idx.building ? new Date(idx.builtAt).toLocaleString() : '-',
+                    idx && idx.watching ? '监听中（' + idx.watchedDirs + ' 个目录）' : '未监听',
+                    idx && idx.lastError ? '<span class="error">' + escapeHtml(idx.lastError) + '</span>' : '',
+                    '<button data-action="' + pause + '" data-name="' + name + '">' + (root.paused ? '恢复' : '暂停') + '</button> ' +
+                    '<button data-action="reindex" data-name="' + name + '"' + (root.paused ? ' disabled' : '') + '>重建索引</button> ' +
+                    '<button class="danger" data-action="remove" data-name="' + name + '">移除</button>'
+                ];
+                return '<tr><td>' + cells.join('</td><td>') + '</td></tr>';
+            }).join('');
+        }
+
+        // 操作按钮的动作和根目录名放在 data-* 属性中，由表格统一处理点击
+        rootsBody.addEventListener('click', (e) => {
+            const btn = e.target.closest('button[data-action]');
+            if (btn) {
+                act(btn.dataset.action, btn.dataset.name);
+            }
+        });
+
+        // escapeHtml 转义文本，结果也可以放在双引号或单引号括起的属性值中
+        function escapeHtml(text) {
+            return String(text).replace(/[&<>"']/g, function(ch) {
+                return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[ch];
+            });
+        }
+
+        refresh();
+        setInterval(refresh, 5000);
+    </script>
+</body>
+</html>
+`
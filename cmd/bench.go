@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// benchQueries 是未指定查询时使用的查询，覆盖短查询、多个词和精确匹配
+var benchQueries = []string{"main", "srcutil", "report xlsx", "'config .json$"}
+
+// 合成路径使用的目录名、文件名片段和扩展名
+var (
+	benchDirs  = []string{"src", "docs", "internal", "vendor", "assets", "build", "test", "cmd", "pkg", "lib", "finance", "reports"}
+	benchWords = []string{"main", "util", "config", "report", "server", "client", "index", "search", "cache", "handler", "model", "data", "image", "backup", "draft", "summary"}
+	benchExts  = []string{".go", ".md", ".json", ".txt", ".xlsx", ".pdf", ".png", ".js", ".css", ".yaml"}
+)
+
+// runBench 在合成的路径上比较 fzf API 与进程内匹配的耗时，不读取文件系统。
+// 两个引擎做相同的工作：排序输出全部匹配，并为每个匹配生成带匹配位置的结果，与服务端的用法相同
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	sizes := fs.String("n", "100000,1000000", "候选数量，逗号分隔")
+	runs := fs.Int("runs", 3, "每个查询重复的次数")
+	fs.StringVar(&defaultMatch.Scheme, "scheme", "path", "评分方案: default、path 或 history")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: fzf-web bench [参数] [查询...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	queries := fs.Args()
+	if len(queries) == 0 {
+		queries = benchQueries
+	}
+	if *runs < 1 {
+		log.Fatalf("-runs 必须大于 0")
+	}
+	opts := defaultMatch
+	if _, err := opts.args(); err != nil {
+		log.Fatalf("无效的匹配选项: %v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "候选数\t查询\t引擎\t匹配数\t最短\t平均\t")
+	for _, field := range strings.Split(*sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n <= 0 {
+			log.Fatalf("无效的候选数量: %q", field)
+		}
+		sets := []*candidateSet{benchSet(n)}
+
+		for _, query := range queries {
+			for _, engine := range []string{engineFzf, engineNative} {
+				var matched int
+				var best, sum time.Duration
+				for i := range *runs {
+					start := time.Now()
+					if matched, err = benchSearch(searchers[engine], query, sets, opts); err != nil {
+						log.Fatalf("%s 搜索失败: %v", engine, err)
+					}
+					elapsed := time.Since(start)
+					sum += elapsed
+					if i == 0 || elapsed < best {
+						best = elapsed
+					}
+				}
+				avg := sum / time.Duration(*runs)
+				fmt.Fprintf(w, "%d\t%q\t%s\t%d\t%s\t%s\t\n", n, query, engine, matched, best.Round(time.Microsecond), avg.Round(time.Microsecond))
+			}
+		}
+	}
+	w.Flush()
+}
+
+// benchSearch 用搜索引擎排序搜索，返回结果数
+func benchSearch(s Searcher, query string, sets []*candidateSet, opts MatchOptions) (int, error) {
+	n := 0
+	err := s.Search(context.Background(), query, sets, opts, true, func(SearchResult) { n++ })
+	return n, err
+}
+
+// benchSet 返回 n 个合成路径的候选集。文件信息为空值，生成结果时不读取文件；
+// 预先转换字符表示，与匹配根目录索引时相同
+func benchSet(n int) *candidateSet {
+	set := &candidateSet{searchTarget: searchTarget{Name: "bench"}, Files: benchPaths(n)}
+	slices.Sort(set.Files)
+	set.Meta = make([]fileMeta, n)
+	set.chars = make([]util.Chars, n)
+	for i, file := range set.Files {
+		set.chars[i] = util.ToChars([]byte(file))
+	}
+	return set
+}
+
+// benchPaths 生成 n 个确定的合成路径，目录深度和文件名长度与常见的源码树相近
+func benchPaths(n int) []string {
+	r := rand.New(rand.NewPCG(1, 2))
+	pick := func(list []string) string { return list[r.IntN(len(list))] }
+
+	paths := make([]string, n)
+	var b strings.Builder
+	for i := range paths {
+		b.Reset()
+		for range 1 + r.IntN(5) {
+			b.WriteString(pick(benchDirs))
+			b.WriteByte('/')
+		}
+		b.WriteString(pick(benchWords))
+		if r.IntN(2) == 0 {
+			b.WriteByte('_')
+			b.WriteString(pick(benchWords))
+		}
+		fmt.Fprintf(&b, "%d%s", i%1000, pick(benchExts))
+		paths[i] = b.String()
+	}
+	return paths
+}
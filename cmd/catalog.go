@@ -0,0 +1,429 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	catalogDSN  string // MySQL 连接串，为空时不启用文件目录
+	catalogHash bool   // 是否计算文件内容的 SHA-256
+
+	catalog *fileCatalog // 未启用时为 nil
+)
+
+// catalogBatchSize 是每次批量写入或删除的行数
+const catalogBatchSize = 500
+
+// CatalogFile 是文件目录表中的一行，记录某个根目录下一个已索引的文件。
+// 文件信息与索引中的相同，符号链接按目标记录，启动时可以直接用来恢复索引
+type CatalogFile struct {
+	ID       uint64    `gorm:"primaryKey"`
+	Root     string    `gorm:"size:191;not null;uniqueIndex:idx_root_path,priority:1"` // 根目录绝对路径
+	PathHash string    `gorm:"size:64;not null;uniqueIndex:idx_root_path,priority:2"`  // 相对路径的 SHA-256，用作唯一键
+	RelPath  string    `gorm:"type:text;not null"`
+	Size     int64     `gorm:"not null"` // 目录为 0
+	ModTime  time.Time `gorm:"not null;index"`
+	Mode     uint32    `gorm:"not null"`
+	Hash     string    `gorm:"size:64"` // 文件内容的 SHA-256，未启用 -catalog-hash 时为空
+	IsDir    bool      `gorm:"not null"`
+	Symlink  bool      `gorm:"not null"` // 条目本身是符号链接
+	Owner    string    `gorm:"size:64"`
+	Group    string    `gorm:"column:group_name;size:64"`
+	MIME     string    `gorm:"column:mime;size:128"` // 没有扩展名的文件读取内容判断的类型
+
+	UpdatedAt time.Time
+}
+
+func (CatalogFile) TableName() string {
+	return "fzf_web_files"
+}
+
+// catalogOp 是一次待写入数据库的索引变化
+type catalogOp struct {
+	root   string
+	sync   []string   // 完整遍历后的全部文件，不在其中的行会被删除
+	upsert []string   // 新增或修改的文件
+	meta   []fileMeta // 与 sync 或 upsert 对应的文件信息
+	remove string     // 删除的文件或目录
+}
+
+// fileCatalog 将索引同步到数据库。所有写入由一个 goroutine 按顺序执行，
+// 不会阻塞索引的更新：队列已满时丢弃这次变化，队列清空后完整同步该根目录
+type fileCatalog struct {
+	db  *gorm.DB
+	ops chan catalogOp
+
+	mu      sync.Mutex
+	dropped map[string]bool // 丢弃过变化、需要完整同步的根目录
+	resync  chan struct{}   // 有根目录需要完整同步时发送
+}
+
+// openCatalog 连接 MySQL 并创建或迁移文件目录表
+func openCatalog(dsn string) (*fileCatalog, error) {
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("无效的数据库连接串: %v", err)
+	}
+	// 读取 mod_time 需要将 DATETIME 解析为 time.Time
+	cfg.ParseTime = true
+
+	db, err := gorm.Open(mysql.New(mysql.Config{DSNConfig: cfg}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Warn),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("连接数据库失败: %v", err)
+	}
+	return newFileCatalog(db)
+}
+
+// newFileCatalog 使用已打开的数据库连接，任何 GORM 方言都可以使用
+func newFileCatalog(db *gorm.DB) (*fileCatalog, error) {
+	if err := db.AutoMigrate(&CatalogFile{}); err != nil {
+		return nil, fmt.Errorf("创建文件目录表失败: %v", err)
+	}
+
+	c := &fileCatalog{
+		db:      db,
+		ops:     make(chan catalogOp, 1024),
+		dropped: map[string]bool{},
+		resync:  make(chan struct{}, 1),
+	}
+	go c.run()
+	return c, nil
+}
+
+// Sync 在完整遍历后同步根目录下的全部文件，meta 是遍历时读取的文件信息。切片不会被修改
+func (c *fileCatalog) Sync(root string, files []string, meta []fileMeta) {
+	c.send(catalogOp{root: root, sync: files, meta: meta})
+}
+
+// Upsert 写入新增或修改的文件
+func (c *fileCatalog) Upsert(root string, files []string, meta []fileMeta) {
+	if len(files) > 0 {
+		c.send(catalogOp{root: root, upsert: files, meta: meta})
+	}
+}
+
+// Remove 删除文件，或目录下的所有文件
+func (c *fileCatalog) Remove(root, rel string) {
+	c.send(catalogOp{root: root, remove: rel})
+}
+
+// send 将变化放入队列，不会阻塞。数据库缓慢或不可用导致队列已满时丢弃这次变化，
+// 并记录该根目录需要完整同步
+func (c *fileCatalog) send(op catalogOp) {
+	select {
+	case c.ops <- op:
+		return
+	default:
+	}
+
+	c.mu.Lock()
+	first := !c.dropped[op.root]
+	c.dropped[op.root] = true
+	c.mu.Unlock()
+	if first {
+		log.Printf("文件目录写入队列已满，%s 的变化将在队列清空后完整同步", op.root)
+	}
+	select {
+	case c.resync <- struct{}{}:
+	default:
+	}
+}
+
+// run 按顺序执行队列中的变化，队列为空时才完整同步丢弃过变化的根目录，
+// 避免之后执行的旧变化覆盖同步的结果
+func (c *fileCatalog) run() {
+	for {
+		select {
+		case op := <-c.ops:
+			c.apply(op)
+			continue
+		default:
+		}
+
+		select {
+		case op := <-c.ops:
+			c.apply(op)
+		case <-c.resync:
+			c.resyncDropped()
+		}
+	}
+}
+
+// apply 执行一次变化
+func (c *fileCatalog) apply(op catalogOp) {
+	var err error
+	switch {
+	case op.sync != nil:
+		err = c.sync(op.root, op.sync, op.meta)
+	case op.upsert != nil:
+		err = c.upsert(op.root, op.upsert, op.meta, nil)
+	default:
+		err = c.remove(op.root, op.remove)
+	}
+	if err != nil {
+		log.Printf("写入文件目录失败 %s: %v", op.root, err)
+	}
+}
+
+// resyncDropped 用根目录索引当前的候选完整同步丢弃过变化的根目录，已移除的根目录跳过
+func (c *fileCatalog) resyncDropped() {
+	c.mu.Lock()
+	dropped := c.dropped
+	c.dropped = map[string]bool{}
+	c.mu.Unlock()
+
+	for root := range dropped {
+		idx := lookupIndex(root)
+		if idx == nil {
+			continue
+		}
+		files, meta, _ := idx.Candidates()
+		if err := c.sync(root, files, meta); err != nil {
+			log.Printf("写入文件目录失败 %s: %v", root, err)
+		}
+	}
+}
+
+// sync 写入有变化的文件并删除已不存在的行，文件信息未变的文件不会重新计算哈希
+func (c *fileCatalog) sync(root string, files []string, meta []fileMeta) error {
+	start := time.Now()
+
+	existing := map[string]CatalogFile{}
+	var rows []CatalogFile
+	err := c.db.Select("id", "path_hash", "size", "mod_time", "mode", "hash", "is_dir", "symlink", "owner", "group_name", "mime").
+		Where("root = ?", root).
+		FindInBatches(&rows, catalogBatchSize*10, func(tx *gorm.DB, batch int) error {
+			for _, row := range rows {
+				existing[row.PathHash] = row
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return err
+	}
+
+	if err := c.upsert(root, files, meta, existing); err != nil {
+		return err
+	}
+
+	// existing 中剩下的行对应的文件已被删除或不再被索引
+	var stale []uint64
+	for _, row := range existing {
+		stale = append(stale, row.ID)
+	}
+	for len(stale) > 0 {
+		n := min(len(stale), catalogBatchSize)
+		if err := c.db.Delete(&CatalogFile{}, stale[:n]).Error; err != nil {
+			return err
+		}
+		stale = stale[n:]
+	}
+
+	log.Printf("文件目录同步完成: %s, %d 个文件, 耗时 %v", root, len(files), time.Since(start))
+	return nil
+}
+
+// upsert 按索引中的文件信息批量写入，不再读取文件信息。existing 不为 nil 时跳过未变化的文件，
+// 并从中删除已处理的条目
+func (c *fileCatalog) upsert(root string, files []string, meta []fileMeta, existing map[string]CatalogFile) error {
+	batch := make([]CatalogFile, 0, catalogBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := c.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "root"}, {Name: "path_hash"}},
+			DoUpdates: clause.AssignmentColumns([]string{"rel_path", "size", "mod_time", "mode", "hash", "is_dir", "symlink", "owner", "group_name", "updated_at"}),
+		}).Create(&batch).Error
+		batch = batch[:0]
+		return err
+	}
+
+	for i, rel := range files {
+		row := catalogRow(root, rel, meta[i])
+		if old, ok := existing[row.PathHash]; ok {
+			delete(existing, row.PathHash)
+			if old.sameMeta(row) && (!catalogHash || old.Hash != "") {
+				continue
+			}
+		}
+
+		if catalogHash && !row.IsDir {
+			var err error
+			row.Hash, err = hashFile(filepath.Join(root, rel))
+			if err != nil {
+				log.Printf("计算文件哈希失败 %s: %v", rel, err)
+			}
+		}
+
+		batch = append(batch, row)
+		if len(batch) == catalogBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// remove 删除文件或目录下所有文件对应的行
+func (c *fileCatalog) remove(root, rel string) error {
+	// 转义 LIKE 的通配符，! 作为转义字符在 MySQL 和 SQLite 中写法相同
+	prefix := strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(rel + string(filepath.Separator))
+	return c.db.
+		Where("root = ? AND (path_hash = ? OR rel_path LIKE ? ESCAPE '!')", root, pathHash(rel), prefix+"%").
+		Delete(&CatalogFile{}).Error
+}
+
+// catalogRow 由索引中的文件信息生成一行，修改时间只保留到秒
+func catalogRow(root, rel string, meta fileMeta) CatalogFile {
+	return CatalogFile{
+		Root:     root,
+		PathHash: pathHash(rel),
+		RelPath:  rel,
+		Size:     meta.Size,
+		ModTime:  meta.ModTime.UTC().Truncate(time.Second),
+		Mode:     uint32(meta.Mode),
+		IsDir:    meta.Mode.IsDir(),
+		Symlink:  meta.Symlink,
+		Owner:    meta.Owner,
+		Group:    meta.Group,
+		MIME:     meta.MIME,
+	}
+}
+
+// sameMeta 判断两行记录的文件信息是否相同
+func (f CatalogFile) sameMeta(o CatalogFile) bool {
+	return f.Size == o.Size && f.ModTime.Equal(o.ModTime) && f.Mode == o.Mode && f.IsDir == o.IsDir &&
+		f.Symlink == o.Symlink && f.Owner == o.Owner && f.Group == o.Group && f.MIME == o.MIME
+}
+
+// meta 返回行记录的文件信息
+func (f CatalogFile) meta() fileMeta {
+	return fileMeta{
+		Size:    f.Size,
+		ModTime: f.ModTime.Local(),
+		Mode:    fs.FileMode(f.Mode),
+		Symlink: f.Symlink,
+		Owner:   f.Owner,
+		Group:   f.Group,
+		MIME:    f.MIME,
+	}
+}
+
+// Load 读取根目录的全部记录，返回按路径排序的文件、对应的文件信息和最近一次写入的时间。
+// 设置了最大文件数时最多读取这么多行
+func (c *fileCatalog) Load(root string) ([]string, []fileMeta, time.Time, error) {
+	var (
+		files    []string
+		meta     []fileMeta
+		syncedAt time.Time
+		rows     []CatalogFile
+	)
+	q := c.db.Where("root = ?", root)
+	if maxFiles > 0 {
+		q = q.Limit(maxFiles)
+	}
+	err := q.FindInBatches(&rows, catalogBatchSize*10, func(tx *gorm.DB, batch int) error {
+		for _, row := range rows {
+			files = append(files, row.RelPath)
+			meta = append(meta, row.meta())
+			if row.UpdatedAt.After(syncedAt) {
+				syncedAt = row.UpdatedAt
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	// 数据库的排序规则与 Go 不同，按字节重新排序
+	sort.Sort(filesByPath{files, meta})
+	return files, meta, syncedAt, nil
+}
+
+// loadCatalog 用文件目录中根目录的记录初始化索引，重启后或其他实例首次打开根目录时可以立即搜索。
+// 记录可能来自其他遍历策略的实例，加载后标记为过期，由随后的完整遍历校对并同步回文件目录
+func (idx *fileIndex) loadCatalog() bool {
+	if catalog == nil || !isRootPath(idx.root) {
+		return false
+	}
+	files, meta, syncedAt, err := catalog.Load(idx.root)
+	if err != nil {
+		log.Printf("读取文件目录失败 %s: %v", idx.root, err)
+		return false
+	}
+	if len(files) == 0 {
+		return false
+	}
+
+	// 监听事件需要遍历器处理新目录
+	w, err := newWalker(idx.root, idx.policy, idx.watchDir)
+	if err != nil {
+		return false
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.walker = w
+	idx.setFiles(files, meta)
+	idx.touch()
+	idx.builtAt = syncedAt
+	idx.stale = true
+
+	log.Printf("已从文件目录加载索引: %s, %d 个文件, 同步时间 %s", idx.root, len(files), syncedAt.Local().Format(time.DateTime))
+	return true
+}
+
+func pathHash(rel string) string {
+	sum := sha256.Sum256([]byte(rel))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashFile 计算文件内容的 SHA-256
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// initCatalog 在设置了 -catalog-dsn 时连接文件目录数据库
+func initCatalog() error {
+	if catalogDSN == "" {
+		return nil
+	}
+
+	c, err := openCatalog(catalogDSN)
+	if err != nil {
+		return err
+	}
+	catalog = c
+	return nil
+}
@@ -0,0 +1,6 @@
+//go:build cgo
+
+package main
+
+// cgoEnabled 表示能否使用需要 cgo 的 SQLite 驱动
+const cgoEnabled = true
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestCatalog 使用临时的 SQLite 数据库创建文件目录。SQLite 驱动需要 cgo，未启用 cgo 时跳过
+func newTestCatalog(t *testing.T) *fileCatalog {
+	t.Helper()
+	if !cgoEnabled {
+		t.Skip("SQLite 驱动需要 cgo")
+	}
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "catalog.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newFileCatalog(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// writeFiles 在 root 下创建文件，内容为文件名
+func writeFiles(t *testing.T, root string, files ...string) {
+	t.Helper()
+	for _, rel := range files {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(rel), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// walkMeta 读取 root 下文件的信息，与遍历时读取的相同
+func walkMeta(t *testing.T, root string, files ...string) []fileMeta {
+	t.Helper()
+	meta := make([]fileMeta, len(files))
+	for i, rel := range files {
+		m, err := statMeta(filepath.Join(root, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta[i] = m
+	}
+	return meta
+}
+
+// catalogRows 返回根目录下的全部行，以相对路径为键
+func catalogRows(t *testing.T, c *fileCatalog, root string) map[string]CatalogFile {
+	t.Helper()
+	var rows []CatalogFile
+	if err := c.db.Where("root = ?", root).Find(&rows).Error; err != nil {
+		t.Fatal(err)
+	}
+	byPath := map[string]CatalogFile{}
+	for _, row := range rows {
+		byPath[row.RelPath] = row
+	}
+	return byPath
+}
+
+// catalogPaths 返回根目录下全部行的相对路径，已排序
+func catalogPaths(t *testing.T, c *fileCatalog, root string) []string {
+	t.Helper()
+	var paths []string
+	for path := range catalogRows(t, c, root) {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+func TestCatalogSync(t *testing.T) {
+	c := newTestCatalog(t)
+	root := t.TempDir()
+	writeFiles(t, root, "a.txt", "dir/b.txt", "dir/c.txt")
+
+	files := []string{"a.txt", "dir", "dir/b.txt", "dir/c.txt"}
+	if err := c.sync(root, files, walkMeta(t, root, files...)); err != nil {
+		t.Fatal(err)
+	}
+	before := catalogRows(t, c, root)
+	if got, want := catalogPaths(t, c, root), []string{"a.txt", "dir", "dir/b.txt", "dir/c.txt"}; !slices.Equal(got, want) {
+		t.Fatalf("同步后的行 = %q，期望 %q", got, want)
+	}
+	if row := before["dir"]; !row.IsDir {
+		t.Errorf("dir 应记录为目录")
+	}
+	if row := before["a.txt"]; row.IsDir || row.Size != int64(len("a.txt")) || row.PathHash != pathHash("a.txt") {
+		t.Errorf("a.txt 的行不正确: %+v", row)
+	}
+
+	// 修改、删除和新增文件后再次同步
+	writeFiles(t, root, "new.txt")
+	if err := os.WriteFile(filepath.Join(root, "a.txt"), []byte("changed content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "dir/b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	files = []string{"a.txt", "dir", "dir/c.txt", "new.txt"}
+	if err := c.sync(root, files, walkMeta(t, root, files...)); err != nil {
+		t.Fatal(err)
+	}
+	after := catalogRows(t, c, root)
+	if got, want := catalogPaths(t, c, root), []string{"a.txt", "dir", "dir/c.txt", "new.txt"}; !slices.Equal(got, want) {
+		t.Fatalf("再次同步后的行 = %q，期望 %q", got, want)
+	}
+	if got := after["a.txt"].Size; got != int64(len("changed content")) {
+		t.Errorf("a.txt 的大小 = %d，期望已更新", got)
+	}
+	// 已有的行原地更新，不重新插入
+	for _, rel := range []string{"a.txt", "dir", "dir/c.txt"} {
+		if after[rel].ID != before[rel].ID {
+			t.Errorf("%s 的 ID 从 %d 变为 %d", rel, before[rel].ID, after[rel].ID)
+		}
+	}
+
+	// 其他根目录的行不受影响
+	other := t.TempDir()
+	writeFiles(t, other, "x.txt")
+	if err := c.sync(other, []string{"x.txt"}, walkMeta(t, other, "x.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.sync(root, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := catalogPaths(t, c, root); len(got) != 0 {
+		t.Errorf("同步空列表后仍有行 %q", got)
+	}
+	if got := catalogPaths(t, c, other); !slices.Equal(got, []string{"x.txt"}) {
+		t.Errorf("其他根目录的行 = %q", got)
+	}
+}
+
+func TestCatalogUpsert(t *testing.T) {
+	c := newTestCatalog(t)
+	root := t.TempDir()
+	writeFiles(t, root, "a.txt")
+
+	// 使用索引中的文件信息，不再读取文件
+	meta := walkMeta(t, root, "a.txt")
+	meta[0].Size, meta[0].Owner, meta[0].Group = 123, "alice", "staff"
+	if err := c.upsert(root, []string{"a.txt"}, meta, nil); err != nil {
+		t.Fatal(err)
+	}
+	if row := catalogRows(t, c, root)["a.txt"]; row.Size != 123 || row.Owner != "alice" || row.Group != "staff" {
+		t.Fatalf("a.txt 的行 = %+v，期望使用给出的文件信息", row)
+	}
+
+	meta[0].Size = 456
+	if err := c.upsert(root, []string{"a.txt"}, meta, nil); err != nil {
+		t.Fatal(err)
+	}
+	rows := catalogRows(t, c, root)
+	if len(rows) != 1 {
+		t.Fatalf("重复写入后有 %d 行，期望 1 行", len(rows))
+	}
+	if got := rows["a.txt"].Size; got != 456 {
+		t.Errorf("a.txt 的大小 = %d，期望已更新", got)
+	}
+}
+
+func TestCatalogHash(t *testing.T) {
+	c := newTestCatalog(t)
+	root := t.TempDir()
+	writeFiles(t, root, "a.txt")
+
+	catalogHash = true
+	defer func() { catalogHash = false }()
+	if err := c.upsert(root, []string{"a.txt"}, walkMeta(t, root, "a.txt"), nil); err != nil {
+		t.Fatal(err)
+	}
+	want, err := hashFile(filepath.Join(root, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := catalogRows(t, c, root)["a.txt"].Hash; got != want || len(got) != 64 {
+		t.Errorf("a.txt 的哈希 = %q，期望 %q", got, want)
+	}
+}
+
+func TestCatalogRemove(t *testing.T) {
+	files := []string{
+		"a.txt", "a.txt.bak",
+		"dir/x", "dir/sub/y", "dir2/x", "dir.txt",
+		// LIKE 的通配符和转义字符在路径中按字面匹配
+		"100%/x", "1000/x", "100%x/y",
+		"a_b/x", "aXb/x", "a_bc/x",
+		"n!b/x", "n!!b/x", "nb/x",
+	}
+	tests := []struct {
+		remove string
+		gone   []string
+	}{
+		{"a.txt", []string{"a.txt"}},
+		{"dir", []string{"dir/x", "dir/sub/y"}},
+		{"100%", []string{"100%/x"}},
+		{"a_b", []string{"a_b/x"}},
+		{"n!b", []string{"n!b/x"}},
+		{"missing", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.remove, func(t *testing.T) {
+			c := newTestCatalog(t)
+			root := t.TempDir()
+			writeFiles(t, root, files...)
+			if err := c.upsert(root, files, walkMeta(t, root, files...), nil); err != nil {
+				t.Fatal(err)
+			}
+			// 其他根目录下同名的行不受影响
+			other := t.TempDir()
+			writeFiles(t, other, files...)
+			if err := c.upsert(other, files, walkMeta(t, other, files...), nil); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := c.remove(root, filepath.FromSlash(tt.remove)); err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for _, file := range files {
+				if !slices.Contains(tt.gone, file) {
+					want = append(want, file)
+				}
+			}
+			slices.Sort(want)
+			if got := catalogPaths(t, c, root); !slices.Equal(got, want) {
+				t.Errorf("删除 %s 后的行 = %q，期望 %q", tt.remove, got, want)
+			}
+			if got := catalogPaths(t, c, other); len(got) != len(files) {
+				t.Errorf("其他根目录剩下 %d 行，期望 %d 行", len(got), len(files))
+			}
+		})
+	}
+}
+
+func TestCatalogLoad(t *testing.T) {
+	c := newTestCatalog(t)
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, "b.txt", "dir/a.txt")
+	files := []string{"b.txt", "dir", "dir/a.txt"}
+	meta := walkMeta(t, root, files...)
+	meta[0].Symlink, meta[0].Owner = true, "alice"
+	if err := c.sync(root, files, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	got, gotMeta, syncedAt, err := c.Load(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, files) {
+		t.Fatalf("读取的文件 = %q，期望 %q", got, files)
+	}
+	if syncedAt.IsZero() {
+		t.Errorf("应返回同步时间")
+	}
+	b := gotMeta[0]
+	if !b.Symlink || b.Owner != "alice" || b.Size != meta[0].Size {
+		t.Errorf("b.txt 的信息 = %+v，期望 %+v", b, meta[0])
+	}
+	if want := meta[0].ModTime.Truncate(time.Second); !b.ModTime.Equal(want) {
+		t.Errorf("b.txt 的修改时间 = %v，期望精确到秒 %v", b.ModTime, want)
+	}
+	if !gotMeta[1].Mode.IsDir() {
+		t.Errorf("dir 应为目录，模式 = %v", gotMeta[0].Mode)
+	}
+
+	// 启动时从文件目录加载根目录的索引，之后由遍历校对
+	saved := catalog
+	catalog = c
+	t.Cleanup(func() { catalog = saved })
+	useRoots(t, &Root{Name: "r", Path: root})
+
+	idx := newFileIndex(root)
+	if !idx.loadCatalog() {
+		t.Fatal("应从文件目录加载索引")
+	}
+	if got, _, _ := idx.Candidates(); !slices.Equal(got, files) {
+		t.Errorf("加载后的候选 = %q", got)
+	}
+	if !idx.stale {
+		t.Errorf("从文件目录加载的索引应等待校对")
+	}
+	if newFileIndex(filepath.Join(root, "dir")).loadCatalog() {
+		t.Errorf("不是根目录的索引不应从文件目录加载")
+	}
+}
+
+func TestCatalogSendFull(t *testing.T) {
+	// 没有执行写入的 goroutine，队列满后的变化应被丢弃而不是阻塞
+	c := &fileCatalog{
+		ops:     make(chan catalogOp, 1),
+		dropped: map[string]bool{},
+		resync:  make(chan struct{}, 1),
+	}
+	c.Upsert("/r", []string{"a"}, make([]fileMeta, 1))
+	c.Remove("/r", "b")
+	c.Sync("/r", []string{"a"}, make([]fileMeta, 1))
+
+	if len(c.ops) != 1 {
+		t.Fatalf("队列中有 %d 个变化，期望 1 个", len(c.ops))
+	}
+	if !c.dropped["/r"] {
+		t.Errorf("丢弃变化后应记录需要完整同步")
+	}
+	select {
+	case <-c.resync:
+	default:
+		t.Errorf("丢弃变化后应请求完整同步")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// commonFlags 注册各子命令共用的根目录、遍历策略和配置文件参数
+func commonFlags(fs *flag.FlagSet) {
+	// 获取当前目录作为默认搜索目录
+	currentDir, err := os.Getwd()
+	if err != nil {
+		log.Fatalf("无法获取当前目录: %v", err)
+	}
+
+	fs.StringVar(&baseDir, "d", currentDir, "指定搜索目录 (简写)")
+	fs.StringVar(&baseDir, "dir", currentDir, "指定搜索目录")
+	fs.Var(&rootFlags, "root", "命名的搜索根目录 name=/path，可重复指定")
+	fs.IntVar(&maxFiles, "max-files", 0, "单个目录最多索引的文件数，0 表示不限制")
+	fs.BoolVar(&defaultPolicy.NoIgnore, "no-ignore", false, "不读取 .gitignore、.ignore 和 .fdignore 文件")
+	fs.BoolVar(&defaultPolicy.Hidden, "hidden", false, "包含隐藏文件和目录")
+	fs.Var((*stringList)(&defaultPolicy.Exclude), "exclude", "额外排除的通配符，可重复指定")
+	fs.Var((*stringList)(&defaultPolicy.Include), "include", "只保留匹配的候选，可重复指定")
+	fs.IntVar(&defaultPolicy.MaxDepth, "max-depth", 0, "最大遍历深度，0 表示不限制")
+	fs.StringVar(&defaultPolicy.Entries, "entries", entriesFiles, "候选条目类型: files、dirs 或 all")
+	fs.BoolVar(&defaultPolicy.Follow, "follow", false, "跟随符号链接")
+	fs.BoolVar(&defaultPolicy.FollowOutside, "follow-outside", false, "允许跟随指向搜索目录之外的符号链接")
+	fs.StringVar(&snapshotDir, "snapshot-dir", "", "保存和加载索引快照的目录，为空时不使用快照")
+	fs.StringVar(&defaultMatch.Engine, "engine", engineFzf, "默认的搜索引擎: "+strings.Join(searchEngines, "、"))
+	fs.StringVar(&fzfPath, "fzf-path", "fzf", "fzf-bin 引擎使用的 fzf 程序路径")
+	fs.StringVar(&configPath, "config", "", "JSON 配置文件路径")
+}
+
+// parseFlags 解析子命令参数，并将配置文件应用到未设置的参数上
+func parseFlags(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "d" || f.Name == "dir" {
+			dirSet = true
+		}
+	})
+
+	if configPath != "" {
+		if err := loadConfig(fs, configPath); err != nil {
+			log.Fatalf("读取配置文件失败: %v", err)
+		}
+	}
+}
+
+// runIndex 不启动服务器，为每个根目录构建索引并写入快照，
+// 供另一台机器上的 serve 通过 -snapshot-dir 加载
+func runIndex(args []string) {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	commonFlags(fs)
+	out := fs.String("out", "", "快照输出路径，多个根目录时为输出目录；为空时写入 -snapshot-dir")
+	parseFlags(fs, args)
+
+	if err := initRoots(rootDefs(), nil); err != nil {
+		log.Fatalf("无效的根目录: %v", err)
+	}
+
+	list := listRoots()
+	for _, root := range list {
+		path := *out
+		switch {
+		case path == "":
+			path = snapshotFile(root.Path)
+			if path == "" {
+				log.Fatalf("请使用 -out 或 -snapshot-dir 指定快照输出位置")
+			}
+		case len(list) > 1:
+			path = filepath.Join(path, root.Name+".snapshot")
+		}
+
+		snap, err := buildSnapshot(root.Path)
+		if err != nil {
+			log.Fatalf("构建索引失败 %s: %v", root.Name, err)
+		}
+		for _, warning := range snap.Stats.Warnings {
+			log.Printf("警告: %s", warning)
+		}
+		if err := writeSnapshot(path, snap); err != nil {
+			log.Fatalf("写入快照失败 %s: %v", path, err)
+		}
+		fmt.Printf("%s: %d 个文件 -> %s\n", root.Name, len(snap.Files), path)
+	}
+}
+
+// runSearch 不启动服务器，在根目录中搜索并逐行输出匹配的路径。
+// 根目录有快照时使用快照，否则直接遍历目录
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	commonFlags(fs)
+	snapshot := fs.String("snapshot", "", "从快照文件读取候选，只能用于单个根目录")
+	sortKey := fs.String("sort", "score", "排序方式: score、"+strings.Join(sortKeys, "、"))
+	desc := fs.Bool("desc", false, "倒序排列")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "用法: fzf-web search [参数] 查询\n")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	query := strings.Join(fs.Args(), " ")
+	if query == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	// 查询中的运算符转换为过滤条件
+	query, filter, err := parseQuery(query, time.Now())
+	if err == nil {
+		err = filter.validate()
+	}
+	if err != nil {
+		log.Fatalf("无效的查询: %v", err)
+	}
+	if err := initRoots(rootDefs(), nil); err != nil {
+		log.Fatalf("无效的根目录: %v", err)
+	}
+
+	list := listRoots()
+	if *snapshot != "" && len(list) > 1 {
+		log.Fatalf("-snapshot 只能用于单个根目录")
+	}
+
+	var sets []*candidateSet
+	for _, root := range list {
+		set := &candidateSet{searchTarget: searchTarget{Name: root.Name, Dir: root.Path, Options: root.Options}}
+
+		// 显式指定的快照直接使用，-snapshot-dir 中的快照需要与当前遍历策略一致
+		var snap *indexSnapshot
+		if *snapshot != "" {
+			var err error
+			if snap, err = readSnapshot(*snapshot); err != nil {
+				log.Fatalf("读取快照失败 %s: %v", *snapshot, err)
+			}
+		} else if path := snapshotFile(root.Path); path != "" {
+			if s, err := readSnapshot(path); err == nil && s.Policy.Equal(defaultPolicy) && s.MaxFiles == maxFiles {
+				snap = s
+			}
+		}
+
+		if snap != nil {
+			set.Files, set.Meta = snap.Files, snap.Meta
+			set.Stats = snap.Stats
+		} else {
+			res, err := walkFiles(context.Background(), root.Path, defaultPolicy)
+			if err != nil {
+				log.Fatalf("遍历目录失败 %s: %v", root.Name, err)
+			}
+			res.sort()
+			set.Files, set.Meta = res.Files, res.Meta
+			set.Stats = res.walkStats
+		}
+		if !filter.empty() {
+			set = filter.apply(set)
+		}
+		sets = append(sets, set)
+	}
+
+	opts := defaultMatch.merge(list[0].Options)
+	if _, err := opts.args(); err != nil {
+		log.Fatalf("无效的匹配选项: %v", err)
+	}
+	results, err := collectResults(context.Background(), searcherFor(opts), query, sets, opts)
+	if err != nil {
+		log.Fatalf("搜索失败: %v", err)
+	}
+	if err := sortResults(results, *sortKey, *desc); err != nil {
+		log.Fatal(err)
+	}
+	for _, warning := range mergeStats(sets).Warnings {
+		log.Printf("警告: %s", warning)
+	}
+	for _, result := range results {
+		if len(sets) > 1 {
+			fmt.Printf("%s:%s\n", result.Root, result.Path)
+		} else {
+			fmt.Println(result.Path)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config 是 -config 指定的 JSON 配置文件，命令行中显式给出的参数优先
+type Config struct {
+	Dir      string       `json:"dir"`      // 搜索目录
+	Roots    []RootConfig `json:"roots"`    // 命名的根目录
+	Allow    []string     `json:"allow"`    // 额外允许搜索的根目录
+	MaxFiles int          `json:"maxFiles"` // 单个目录最多索引的文件数
+	Policy   WalkPolicy   `json:"policy"`   // 默认遍历策略
+	Options  MatchOptions `json:"options"`  // 默认匹配选项，可被根目录和请求覆盖
+	FzfPath  string       `json:"fzfPath"`  // fzf-bin 引擎使用的 fzf 程序
+
+	AdminToken  string `json:"adminToken"`  // 管理接口的访问令牌
+	CatalogDSN  string `json:"catalogDSN"`  // 文件目录的 MySQL 连接串
+	CatalogHash bool   `json:"catalogHash"` // 是否在文件目录中记录内容哈希
+
+	SnapshotDir      string `json:"snapshotDir"`      // 索引快照目录
+	SnapshotInterval string `json:"snapshotInterval"` // 定期保存快照的间隔，例如 "10m"
+}
+
+// loadConfig 读取配置文件，并应用到命令行中未设置的参数上
+func loadConfig(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return err
+	}
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if cfg.Dir != "" && !set["d"] && !set["dir"] {
+		baseDir = cfg.Dir
+		dirSet = true
+	}
+	if !set["root"] {
+		configRoots = cfg.Roots
+	}
+	if _, err := cfg.Options.args(); err != nil {
+		return err
+	}
+	engine := defaultMatch.Engine
+	defaultMatch = defaultMatch.merge(cfg.Options)
+	if set["engine"] {
+		defaultMatch.Engine = engine
+	}
+	if cfg.FzfPath != "" && !set["fzf-path"] {
+		fzfPath = cfg.FzfPath
+	}
+	if !set["allow"] {
+		allowFlags = cfg.Allow
+	}
+	if !set["admin-token"] {
+		adminToken = cfg.AdminToken
+	}
+	if !set["catalog-dsn"] {
+		catalogDSN = cfg.CatalogDSN
+	}
+	if !set["catalog-hash"] {
+		catalogHash = cfg.CatalogHash
+	}
+	if !set["snapshot-dir"] {
+		snapshotDir = cfg.SnapshotDir
+	}
+	if cfg.SnapshotInterval != "" && !set["snapshot-interval"] {
+		d, err := time.ParseDuration(cfg.SnapshotInterval)
+		if err != nil {
+			return fmt.Errorf("无效的 snapshotInterval: %v", err)
+		}
+		snapshotInterval = d
+	}
+	if !set["max-files"] {
+		maxFiles = cfg.MaxFiles
+	}
+
+	p := cfg.Policy
+	if !set["no-ignore"] {
+		defaultPolicy.NoIgnore = p.NoIgnore
+	}
+	if !set["hidden"] {
+		defaultPolicy.Hidden = p.Hidden
+	}
+	if !set["exclude"] {
+		defaultPolicy.Exclude = p.Exclude
+	}
+	if !set["include"] {
+		defaultPolicy.Include = p.Include
+	}
+	if !set["max-depth"] {
+		defaultPolicy.MaxDepth = p.MaxDepth
+	}
+	if !set["entries"] {
+		defaultPolicy.Entries = p.Entries
+	}
+	if !set["follow"] {
+		defaultPolicy.Follow = p.Follow
+	}
+	if !set["follow-outside"] {
+		defaultPolicy.FollowOutside = p.FollowOutside
+	}
+	return nil
+}
+
+// stringList 是可重复指定的字符串参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// Searcher 是搜索引擎，在候选中匹配查询，每得到一个结果就调用 emit。
+// sorted 为 true 时按相关度从高到低输出；为 false 时按匹配的先后输出，
+// 调用方需要按得分排序。emit 在同一个 goroutine 中依次调用。
+// ctx 取消时尽快停止并返回 ctx 的错误。
+// Positions 为已匹配的路径重新计算匹配位置，结果集只保存得分，翻页时为一页结果计算
+type Searcher interface {
+	Search(ctx context.Context, query string, sets []*candidateSet, opts MatchOptions, sorted bool, emit func(SearchResult)) error
+	Positions(query string, opts MatchOptions, paths []string) ([][]int, error)
+}
+
+const (
+	engineFzf       = "fzf"       // fzf 的 Go API
+	engineNative    = "native"    // 进程内并行调用 fzf 的匹配算法
+	engineFzfBinary = "fzf-bin"   // 外部 fzf 程序
+	engineSubstring = "substring" // 子串匹配
+	engineRegex     = "regex"     // 正则表达式匹配
+)
+
+var (
+	searchEngines = []string{engineFzf, engineNative, engineFzfBinary, engineSubstring, engineRegex}
+	searchers     = map[string]Searcher{
+		engineFzf:       fzfAPISearcher{},
+		engineNative:    nativeSearcher{},
+		engineFzfBinary: fzfBinary,
+		engineSubstring: substringSearcher{},
+		engineRegex:     regexSearcher{},
+	}
+)
+
+// searcherFor 返回匹配选项指定的搜索引擎，opts 是已校验的匹配选项
+func searcherFor(opts MatchOptions) Searcher {
+	return searchers[opts.engine()]
+}
+
+// collectResults 执行搜索并返回按相关度排序的全部结果
+func collectResults(ctx context.Context, s Searcher, query string, sets []*candidateSet, opts MatchOptions) ([]SearchResult, error) {
+	return streamResults(ctx, s, query, sets, opts, func(SearchResult) {})
+}
+
+// streamResults 执行搜索，每得到一个结果就调用 onResult，匹配完成后返回用 rankResults 排序的全部结果。
+// /api/search、流式搜索、会话和 search 子命令都由此排序，同样的查询得到同样的顺序。
+// 搜索引擎不排序，以便取消时立即停止匹配
+func streamResults(ctx context.Context, s Searcher, query string, sets []*candidateSet, opts MatchOptions, onResult func(SearchResult)) ([]SearchResult, error) {
+	var results []SearchResult
+	err := s.Search(ctx, query, sets, opts, false, func(result SearchResult) {
+		results = append(results, result)
+		onResult(result)
+	})
+	rankResults(results)
+	return results, err
+}
+
+// rankResults 按相关度排序，规则与 fzf 相同：先比较得分和 tiebreak 决定的比较值，都相同时按候选的顺序。
+// 各搜索引擎在结果中记录排序用的键，不排序输出的结果由调用方用它排序
+func rankResults(results []SearchResult) {
+	slices.SortFunc(results, func(a, b SearchResult) int {
+		if a.rank.before(b.rank) {
+			return -1
+		}
+		return 1
+	})
+}
+
+// fzfRanking 决定 fzf 是否排序，返回搜索时代替 emit 的 collect 和 fzf 结束后调用的 done。
+// 搜索多个根目录时候选以 "根目录名\t相对路径" 整行送入 fzf，tiebreak 按整行计算，
+// 不同根目录的结果不能按 fzf 的顺序合并：这时 fzf 不排序，done 按路径计算的比较值用 rankResults 排序后输出
+func fzfRanking(ctx context.Context, sets int, sorted bool, emit func(SearchResult)) (fzfSorted bool, collect func(SearchResult), done func() error) {
+	if !sorted || sets <= 1 {
+		return sorted, emit, func() error { return nil }
+	}
+	var results []SearchResult
+	collect = func(result SearchResult) {
+		results = append(results, result)
+	}
+	done = func() error {
+		rankResults(results)
+		for i, result := range results {
+			if i%1024 == 0 && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			emit(result)
+		}
+		return ctx.Err()
+	}
+	return false, collect, done
+}
+
+// fzfPositions 用与 fzf 相同的匹配算法计算路径的匹配位置，供使用 fzf 匹配算法的引擎使用
+func fzfPositions(query string, opts MatchOptions, paths []string) [][]int {
+	defer fzfGlobals.share()()
+	m := newQueryMatcher(query, opts)
+	positions := make([][]int, len(paths))
+	for i, path := range paths {
+		_, positions[i], _ = m.Match(path)
+	}
+	return positions
+}
+
+// scanPositions 用 match 计算路径的匹配位置，供不使用 fzf 的引擎使用
+func scanPositions(paths []string, match func(path string) (int, []int, bool)) [][]int {
+	positions := make([][]int, len(paths))
+	for i, path := range paths {
+		_, positions[i], _ = match(path)
+	}
+	return positions
+}
+
+// minFzfVersion 是 fzf-bin 引擎要求的最低 fzf 版本，支持本程序使用的全部选项
+const minFzfVersion = "0.42.0"
+
+// fzfPath 是 fzf-bin 引擎使用的 fzf 程序
+var fzfPath = "fzf"
+
+// fzfBinarySearcher 启动外部 fzf 程序搜索，参数和输出格式与 fzfAPISearcher 相同，
+// 用于使用与内置版本不同的 fzf。第一次使用时检查程序版本
+type fzfBinarySearcher struct {
+	once    sync.Once
+	version string
+	err     error
+}
+
+var fzfBinary = &fzfBinarySearcher{}
+
+// check 检查 fzf 程序是否可用且版本满足要求，结果只检查一次
+func (b *fzfBinarySearcher) check() (string, error) {
+	b.once.Do(func() {
+		out, err := exec.Command(fzfPath, "--version").Output()
+		if err != nil {
+			b.err = fmt.Errorf("fzf 程序不可用 (%s): %v", fzfPath, err)
+			return
+		}
+		// 输出形如 "0.64.0 (0fa3b2d)"
+		fields := strings.Fields(string(out))
+		if len(fields) == 0 {
+			b.err = fmt.Errorf("无法识别 fzf 版本: %q", out)
+			return
+		}
+		b.version = fields[0]
+		if compareVersions(b.version, minFzfVersion) < 0 {
+			b.err = fmt.Errorf("fzf 版本 %s 过低，至少需要 %s", b.version, minFzfVersion)
+		}
+	})
+	return b.version, b.err
+}
+
+// compareVersions 按数字逐段比较 x.y.z 形式的版本号，忽略 "-" 之后的部分
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.SplitN(a, "-", 2)[0], ".")
+	bs := strings.Split(strings.SplitN(b, "-", 2)[0], ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return cmpInt(int64(x), int64(y))
+		}
+	}
+	return 0
+}
+
+func (b *fzfBinarySearcher) Search(ctx context.Context, query string, sets []*candidateSet, opts MatchOptions, sorted bool, emit func(SearchResult)) error {
+	if _, err := b.check(); err != nil {
+		return err
+	}
+	fzfSorted, collect, done := fzfRanking(ctx, len(sets), sorted, emit)
+	args, err := fzfArgs(query, len(sets), opts, fzfSorted)
+	if err != nil {
+		return err
+	}
+	// fzf 程序自己评分，这里只为输出的结果重新计算得分和位置
+	defer fzfGlobals.share()()
+
+	// 不使用用户环境中的默认选项，避免冲突
+	cmd := exec.CommandContext(ctx, fzfPath, args...)
+	cmd.Env = append(os.Environ(), "FZF_DEFAULT_OPTS=", "FZF_DEFAULT_OPTS_FILE=")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("fzf 程序启动失败: %v", err)
+	}
+
+	// 写入候选后关闭 stdin，fzf 退出或被取消时写入失败并停止
+	go func() {
+		defer stdin.Close()
+		w := bufio.NewWriter(stdin)
+		feedCandidates(sets, func(line string) bool {
+			_, err := fmt.Fprintln(w, line)
+			return err == nil
+		})
+		w.Flush()
+	}()
+
+	out := newFzfOutput(query, sets, opts)
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, 1024*1024)
+	first := true
+	for scanner.Scan() {
+		if first || ctx.Err() != nil {
+			first = false
+			continue // 第一行是查询；已取消时只排空输出
+		}
+		if result, ok := out.result(scanner.Text()); ok {
+			collect(result)
+		}
+	}
+
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// 没有匹配时 fzf 的退出码为 1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("fzf 程序执行失败: %v", err)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return done()
+}
+
+func (b *fzfBinarySearcher) Positions(query string, opts MatchOptions, paths []string) ([][]int, error) {
+	return fzfPositions(query, opts, paths), nil
+}
+
+// scanCandidates 用 match 逐个检查候选，供不使用 fzf 的引擎使用。
+// match 返回得分和匹配字符的下标，不匹配时返回 false。结果只按得分排序，得分相同时按候选的顺序
+func scanCandidates(ctx context.Context, sets []*candidateSet, sorted bool, emit func(SearchResult), match func(path string) (int, []int, bool)) error {
+	var results []SearchResult
+	n := 0
+	for _, set := range sets {
+		for i, file := range set.Files {
+			// 每检查一批候选确认一次是否已取消
+			if n++; n%1024 == 0 && ctx.Err() != nil {
+				return ctx.Err()
+			}
+			score, positions, ok := match(file)
+			if !ok {
+				continue
+			}
+			result, ok := set.result(i, score, positions)
+			if !ok {
+				continue
+			}
+			result.rank.points[3] = math.MaxUint16 - util.AsUint16(score)
+			result.rank.order = n - 1
+			if sorted {
+				results = append(results, result)
+			} else {
+				emit(result)
+			}
+		}
+	}
+
+	rankResults(results)
+	for i, result := range results {
+		if i%1024 == 0 && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		emit(result)
+	}
+	return ctx.Err()
+}
+
+// caseSensitive 判断词是否区分大小写，规则与 fzf 相同：智能模式下含大写字母时区分
+func caseSensitive(opts MatchOptions, text string) bool {
+	return opts.Case == "respect" ||
+		(opts.Case == "" || opts.Case == "smart") && text != strings.ToLower(text)
+}
+
+// substringSearcher 按空格分隔的词做子串匹配，所有词都出现在路径中才匹配。
+// 大小写规则与 fzf 相同，其他匹配选项不适用。
+// 每个词取最后一次出现的位置，按长度计分，出现在文件名中的词得分加倍
+type substringSearcher struct{}
+
+func (substringSearcher) Search(ctx context.Context, query string, sets []*candidateSet, opts MatchOptions, sorted bool, emit func(SearchResult)) error {
+	return scanCandidates(ctx, sets, sorted, emit, substringMatcher(query, opts))
+}
+
+func (substringSearcher) Positions(query string, opts MatchOptions, paths []string) ([][]int, error) {
+	return scanPositions(paths, substringMatcher(query, opts)), nil
+}
+
+// substringMatcher 返回子串匹配的函数，返回值与 scanCandidates 的 match 相同
+func substringMatcher(query string, opts MatchOptions) func(path string) (int, []int, bool) {
+	type term struct {
+		text          []rune
+		caseSensitive bool
+	}
+	var terms []term
+	for _, field := range strings.Fields(query) {
+		t := term{text: []rune(field), caseSensitive: caseSensitive(opts, field)}
+		if !t.caseSensitive {
+			for i, r := range t.text {
+				t.text[i] = unicode.ToLower(r)
+			}
+		}
+		terms = append(terms, t)
+	}
+
+	return func(path string) (int, []int, bool) {
+		runes := []rune(path)
+		lower := make([]rune, len(runes))
+		for i, r := range runes {
+			lower[i] = unicode.ToLower(r)
+		}
+		nameStart := lastIndexRunes(runes, []rune{'/'}) + 1
+
+		score := 0
+		var positions []int
+		for _, t := range terms {
+			hay := lower
+			if t.caseSensitive {
+				hay = runes
+			}
+			i := lastIndexRunes(hay, t.text)
+			if i < 0 {
+				return 0, nil, false
+			}
+			s := len(t.text) * 16
+			if i >= nameStart {
+				s *= 2
+			}
+			score += s
+			for j := range t.text {
+				positions = append(positions, i+j)
+			}
+		}
+		slices.Sort(positions)
+		return score, slices.Compact(positions), true
+	}
+}
+
+// lastIndexRunes 返回 needle 在 hay 中最后一次出现的下标，没有时返回 -1
+func lastIndexRunes(hay, needle []rune) int {
+	for i := len(hay) - len(needle); i >= 0; i-- {
+		if slices.Equal(hay[i:i+len(needle)], needle) {
+			return i
+		}
+	}
+	return -1
+}
+
+// regexSearcher 将整个查询作为 Go 正则表达式匹配路径。
+// 大小写规则与 fzf 相同，其他匹配选项不适用。按匹配的字符数计分，匹配到文件名的得分加倍
+type regexSearcher struct{}
+
+func (regexSearcher) Search(ctx context.Context, query string, sets []*candidateSet, opts MatchOptions, sorted bool, emit func(SearchResult)) error {
+	match, err := regexMatcher(query, opts)
+	if err != nil {
+		return err
+	}
+	return scanCandidates(ctx, sets, sorted, emit, match)
+}
+
+func (regexSearcher) Positions(query string, opts MatchOptions, paths []string) ([][]int, error) {
+	match, err := regexMatcher(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	return scanPositions(paths, match), nil
+}
+
+// regexMatcher 返回正则表达式匹配的函数，返回值与 scanCandidates 的 match 相同
+func regexMatcher(query string, opts MatchOptions) (func(path string) (int, []int, bool), error) {
+	expr := query
+	if !caseSensitive(opts, query) {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的正则表达式: %v", err)
+	}
+
+	return func(path string) (int, []int, bool) {
+		matches := re.FindAllStringIndex(path, -1)
+		if matches == nil {
+			return 0, nil, false
+		}
+
+		// 匹配位置按字节计，转换为字符下标
+		nameStart := utf8.RuneCountInString(path[:strings.LastIndexByte(path, '/')+1])
+		score := 0
+		var positions []int
+		for _, m := range matches {
+			start := utf8.RuneCountInString(path[:m[0]])
+			end := start + utf8.RuneCountInString(path[m[0]:m[1]])
+			for i := start; i < end; i++ {
+				positions = append(positions, i)
+				score += 16
+				if i >= nameStart {
+					score += 16
+				}
+			}
+		}
+		return score, positions, true
+	}, nil
+}
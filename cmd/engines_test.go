@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRankResults(t *testing.T) {
+	result := func(path string, order int, points ...uint16) SearchResult {
+		r := SearchResult{Path: path, rank: rankKey{order: order}}
+		copy(r.rank.points[:], points)
+		return r
+	}
+	results := []SearchResult{
+		result("d", 3, 0, 0, 5, 10),
+		result("a", 0, 0, 0, 9, 20),
+		result("c", 2, 0, 0, 5, 10),
+		result("b", 1, 0, 0, 1, 10),
+		result("e", 4, 0, 7, 1, 10),
+	}
+	// 从后向前比较，都相同时按候选的顺序
+	rankResults(results)
+	var got []string
+	for _, r := range results {
+		got = append(got, r.Path)
+	}
+	if want := []string{"b", "e", "c", "d", "a"}; !slices.Equal(got, want) {
+		t.Errorf("排序结果 = %q，期望 %q", got, want)
+	}
+}
+
+// conformanceEngines 返回参与一致性测试的搜索引擎，没有 fzf 程序时跳过 fzf-bin
+func conformanceEngines(t *testing.T) []string {
+	engines := []string{engineFzf, engineNative, engineSubstring, engineRegex}
+	if _, err := exec.LookPath(fzfPath); err != nil {
+		t.Logf("跳过 %s: %v", engineFzfBinary, err)
+	} else if _, err := fzfBinary.check(); err != nil {
+		t.Logf("跳过 %s: %v", engineFzfBinary, err)
+	} else {
+		engines = append(engines, engineFzfBinary)
+	}
+	return engines
+}
+
+// conformanceSets 返回两个根目录的候选，两个根目录中有相同的路径
+func conformanceSets() []*candidateSet {
+	return []*candidateSet{
+		testSet("a", "/a", "src/main.go", "docs/manual.md", "cmd/server/main_test.go", "README.md"),
+		testSet("b", "/b", "mlib/a/in.txt", "Main.go", "src/main.go"),
+	}
+}
+
+// sortedSearch 返回搜索引擎自己排序输出的结果
+func sortedSearch(t *testing.T, s Searcher, query string, sets []*candidateSet, opts MatchOptions) []SearchResult {
+	t.Helper()
+	var results []SearchResult
+	err := s.Search(context.Background(), query, sets, opts, true, func(r SearchResult) {
+		results = append(results, r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+// rootPaths 返回结果的 根目录名:路径，保持结果的顺序
+func rootPaths(results []SearchResult) []string {
+	var paths []string
+	for _, r := range results {
+		paths = append(paths, r.Root+":"+r.Path)
+	}
+	return paths
+}
+
+func TestEngineConformance(t *testing.T) {
+	tests := []struct {
+		query     string
+		fuzzy     []string // fzf、native 和 fzf-bin 的结果，按相关度排序
+		substring []string
+		regex     []string
+		chars     string // 匹配位置上的字符，不区分大小写
+	}{
+		{
+			// 得分相同时按路径比较 tiebreak，都相同时按根目录的顺序；substring 和 regex 只按得分排序
+			query:     "main",
+			fuzzy:     []string{"b:Main.go", "a:src/main.go", "b:src/main.go", "a:cmd/server/main_test.go", "b:mlib/a/in.txt"},
+			substring: []string{"a:cmd/server/main_test.go", "a:src/main.go", "b:Main.go", "b:src/main.go"},
+			regex:     []string{"a:cmd/server/main_test.go", "a:src/main.go", "b:Main.go", "b:src/main.go"},
+			chars:     "main",
+		},
+		{
+			// 含大写字母时区分大小写
+			query:     "Main",
+			fuzzy:     []string{"b:Main.go"},
+			substring: []string{"b:Main.go"},
+			regex:     []string{"b:Main.go"},
+			chars:     "main",
+		},
+		{
+			// fzf 的后缀匹配和反向匹配，其他引擎按字面匹配
+			query: "main go$",
+			fuzzy: []string{"b:Main.go", "a:src/main.go", "b:src/main.go", "a:cmd/server/main_test.go"},
+			chars: "maingo",
+		},
+		{
+			query: "!test main",
+			fuzzy: []string{"b:Main.go", "a:src/main.go", "b:src/main.go", "b:mlib/a/in.txt"},
+			chars: "main",
+		},
+		{
+			query: "ma.n",
+			regex: []string{"a:cmd/server/main_test.go", "a:src/main.go", "b:Main.go", "b:src/main.go"},
+			chars: "main",
+		},
+	}
+
+	for _, engine := range conformanceEngines(t) {
+		opts := defaultMatch.merge(MatchOptions{Engine: engine})
+		s := searcherFor(opts)
+		for _, tt := range tests {
+			want := tt.fuzzy
+			switch engine {
+			case engineSubstring:
+				want = tt.substring
+			case engineRegex:
+				want = tt.regex
+			}
+
+			t.Run(engine+"/"+tt.query, func(t *testing.T) {
+				sets := conformanceSets()
+				results := sortedSearch(t, s, tt.query, sets, opts)
+				got := rootPaths(results)
+				if !slices.Equal(got, want) {
+					t.Fatalf("结果 = %q，期望 %q", got, want)
+				}
+
+				// 不排序的结果用 rankResults 排序后与搜索引擎排序的输出相同
+				ranked, err := collectResults(context.Background(), s, tt.query, sets, opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if r := rootPaths(ranked); !slices.Equal(r, got) {
+					t.Errorf("不排序的结果排序后为 %q，期望 %q", r, got)
+				}
+
+				var paths []string
+				for _, r := range results {
+					paths = append(paths, r.Path)
+				}
+				positions, err := s.Positions(tt.query, opts, paths)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for i, r := range results {
+					// 结果来自所在根目录的候选集
+					set := sets[slices.IndexFunc(sets, func(set *candidateSet) bool { return set.Name == r.Root })]
+					if r.set != set || set.Files[r.index] != r.Path || r.Filename != filepath.Base(r.Path) {
+						t.Errorf("%s:%s 的候选集或下标不正确", r.Root, r.Path)
+					}
+
+					if !slices.Equal(r.Positions, positions[i]) {
+						t.Errorf("%s 的匹配位置 %v 与 Positions 的 %v 不同", r.Path, r.Positions, positions[i])
+					}
+					runes := []rune(r.Path)
+					var matched []rune
+					for _, p := range r.Positions {
+						if p < 0 || p >= len(runes) {
+							t.Fatalf("%s 的匹配位置 %v 越界", r.Path, r.Positions)
+						}
+						matched = append(matched, runes[p])
+					}
+					if !slices.IsSorted(r.Positions) || strings.ToLower(string(matched)) != tt.chars {
+						t.Errorf("%s 的匹配位置 %v 上的字符为 %q，期望 %q", r.Path, r.Positions, string(matched), tt.chars)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestEnginesMergeRoots(t *testing.T) {
+	// 多个根目录的结果按路径比较：Main.go 的 tiebreak 优于 cmd/server/main_test.go，不受根目录名影响
+	sets := conformanceSets()
+	native := defaultMatch.merge(MatchOptions{Engine: engineNative})
+	want := rootPaths(sortedSearch(t, searcherFor(native), "main", sets, native))
+	if slices.Index(want, "b:Main.go") > slices.Index(want, "a:cmd/server/main_test.go") {
+		t.Fatalf("native 的结果 = %q，b:Main.go 应在 a:cmd/server/main_test.go 之前", want)
+	}
+
+	for _, engine := range conformanceEngines(t) {
+		if engine == engineSubstring || engine == engineRegex {
+			continue
+		}
+		opts := defaultMatch.merge(MatchOptions{Engine: engine})
+		if got := rootPaths(sortedSearch(t, searcherFor(opts), "main", sets, opts)); !slices.Equal(got, want) {
+			t.Errorf("%s 的结果 = %q，期望与 native 相同 %q", engine, got, want)
+		}
+	}
+}
+
+func TestStreamResults(t *testing.T) {
+	// 流式搜索按匹配的先后发送每个结果，汇总的顺序与 /api/search 相同
+	for _, engine := range conformanceEngines(t) {
+		opts := defaultMatch.merge(MatchOptions{Engine: engine})
+		s := searcherFor(opts)
+		sets := conformanceSets()
+		want, err := collectResults(context.Background(), s, "main", sets, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var streamed []string
+		results, err := streamResults(context.Background(), s, "main", sets, opts, func(r SearchResult) {
+			streamed = append(streamed, r.Root+":"+r.Path)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rootPaths(results); !slices.Equal(got, rootPaths(want)) {
+			t.Errorf("%s 流式搜索的汇总 = %q，期望 %q", engine, got, rootPaths(want))
+		}
+		if !slices.Equal(slices.Sorted(slices.Values(streamed)), slices.Sorted(slices.Values(rootPaths(want)))) {
+			t.Errorf("%s 流式发送的结果 = %q，期望 %q", engine, streamed, rootPaths(want))
+		}
+	}
+}
+
+func TestEngineCancel(t *testing.T) {
+	// 匹配的候选足够多，取消后不应输出全部结果
+	var files []string
+	for i := range 5000 {
+		files = append(files, fmt.Sprintf("dir%d/main%d.go", i%50, i))
+	}
+
+	for _, engine := range conformanceEngines(t) {
+		opts := defaultMatch.merge(MatchOptions{Engine: engine})
+		s := searcherFor(opts)
+		for _, sorted := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s/sorted=%v", engine, sorted), func(t *testing.T) {
+				sets := []*candidateSet{testSet("a", "/a", files...)}
+
+				// 开始前已取消
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				n := 0
+				err := s.Search(ctx, "main", sets, opts, sorted, func(SearchResult) { n++ })
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("已取消时返回 %v，期望 context.Canceled", err)
+				}
+				if n == len(files) {
+					t.Errorf("已取消时仍然输出了全部 %d 个结果", n)
+				}
+
+				// 收到第一个结果后取消
+				ctx, cancel = context.WithCancel(context.Background())
+				defer cancel()
+				n = 0
+				err = s.Search(ctx, "main", sets, opts, sorted, func(SearchResult) {
+					n++
+					cancel()
+				})
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("匹配中取消时返回 %v，期望 context.Canceled", err)
+				}
+				if n == 0 || n == len(files) {
+					t.Errorf("匹配中取消时输出了 %d 个结果，共 %d 个", n, len(files))
+				}
+			})
+		}
+	}
+}
+
+func TestEnginesConcurrent(t *testing.T) {
+	// fzf.Run 每次启动都会重写 fzf 的全局状态，与其他匹配同时进行时不能互相影响，用 -race 运行时检查数据竞争
+	sets := conformanceSets()
+	var runs []MatchOptions
+	for _, engine := range []string{engineFzf, engineNative} {
+		for _, tiebreak := range []string{"", "end", "begin,length"} {
+			runs = append(runs, defaultMatch.merge(MatchOptions{Engine: engine, Tiebreak: tiebreak}))
+		}
+	}
+	want := make([][]string, len(runs))
+	for i, opts := range runs {
+		results, err := collectResults(context.Background(), searcherFor(opts), "main", sets, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[i] = rootPaths(results)
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		for i, opts := range runs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results, err := collectResults(context.Background(), searcherFor(opts), "main", sets, opts)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got := rootPaths(results); !slices.Equal(got, want[i]) {
+					t.Errorf("%s/%s 同时搜索的结果 = %q，期望 %q", opts.engine(), opts.Tiebreak, got, want[i])
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// fileIDOf 在没有 inode 的平台上使用解析后的真实路径识别目录
+func fileIDOf(path string, info fs.FileInfo) fileID {
+	return fileID{path: realPath(path)}
+}
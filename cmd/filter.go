@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 条目类型过滤
+const (
+	typeFile    = "file"    // 不是目录的条目，符号链接按目标判断
+	typeDir     = "dir"     // 目录
+	typeSymlink = "symlink" // 符号链接本身
+)
+
+// mimeCategories 是除 MIME 顶级类型（image、video、audio、text 等）外可用的类别
+var mimeCategories = map[string][]string{
+	"document": {
+		"application/pdf",
+		"application/msword",
+		"application/vnd.ms-excel",
+		"application/vnd.ms-powerpoint",
+		"application/vnd.openxmlformats-officedocument.",
+		"application/vnd.oasis.opendocument.",
+		"application/rtf",
+	},
+	"archive": {
+		"application/zip",
+		"application/gzip",
+		"application/x-tar",
+		"application/x-7z-compressed",
+		"application/vnd.rar",
+		"application/x-bzip2",
+		"application/x-xz",
+	},
+}
+
+// mimeTopLevels 是可作为类别使用的 MIME 顶级类型
+var mimeTopLevels = []string{"application", "audio", "font", "image", "text", "video"}
+
+// MetaFilter 按文件信息和所在目录过滤候选，在匹配之前应用。未设置的条件不限制，
+// 多个条件同时满足才保留，Ext、MIME 和 In 中的值满足任意一个即可。
+// 查询中的运算符与请求的条件合并时，两边都有的列表取交集
+type MetaFilter struct {
+	MinSize *int64     `json:"minSize,omitempty"` // 最小字节数（含）
+	MaxSize *int64     `json:"maxSize,omitempty"` // 最大字节数（含）
+	After   *time.Time `json:"after,omitempty"`   // 修改时间不早于
+	Before  *time.Time `json:"before,omitempty"`  // 修改时间早于
+	Ext     []string   `json:"ext,omitempty"`     // 扩展名，不区分大小写，可以带点
+	Type    string     `json:"type,omitempty"`    // 条目类型: file、dir 或 symlink
+	MIME    []string   `json:"mime,omitempty"`    // MIME 类别: 顶级类型、document、archive 或完整的类型
+	In      []string   `json:"in,omitempty"`      // 只保留这些目录下的候选，相对于根目录
+}
+
+// empty 判断是否没有任何条件
+func (f *MetaFilter) empty() bool {
+	return f.MinSize == nil && f.MaxSize == nil && f.After == nil && f.Before == nil &&
+		len(f.Ext) == 0 && f.Type == "" && len(f.MIME) == 0 && len(f.In) == 0
+}
+
+// validate 校验条件，并将扩展名和 MIME 类别规范化为小写，目录规范化为以 / 分隔的相对路径
+func (f *MetaFilter) validate() error {
+	if f.MinSize != nil && *f.MinSize < 0 || f.MaxSize != nil && *f.MaxSize < 0 {
+		return fmt.Errorf("大小不能为负数")
+	}
+	if f.MinSize != nil && f.MaxSize != nil && *f.MinSize > *f.MaxSize {
+		return fmt.Errorf("最小大小 %d 大于最大大小 %d", *f.MinSize, *f.MaxSize)
+	}
+	if f.After != nil && f.Before != nil && !f.After.Before(*f.Before) {
+		return fmt.Errorf("修改时间范围为空: %s 至 %s", f.After.Format(time.DateTime), f.Before.Format(time.DateTime))
+	}
+	switch f.Type {
+	case "", typeFile, typeDir, typeSymlink:
+	default:
+		return fmt.Errorf("无效的类型: %q，可选 %s、%s 或 %s", f.Type, typeFile, typeDir, typeSymlink)
+	}
+
+	for i, ext := range f.Ext {
+		f.Ext[i] = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if f.Ext[i] == "" || strings.ContainsAny(f.Ext[i], `/\`) {
+			return fmt.Errorf("无效的扩展名: %q", ext)
+		}
+	}
+	for i, category := range f.MIME {
+		f.MIME[i] = strings.ToLower(strings.TrimSpace(category))
+		if strings.Contains(f.MIME[i], "/") || slices.Contains(mimeTopLevels, f.MIME[i]) || mimeCategories[f.MIME[i]] != nil {
+			continue
+		}
+		return fmt.Errorf("无效的 MIME 类别: %q", category)
+	}
+	for i, dir := range f.In {
+		scope, err := scopeOf(dir)
+		if err != nil {
+			return err
+		}
+		f.In[i] = scope
+	}
+	return nil
+}
+
+// match 判断候选是否满足条件
+func (f *MetaFilter) match(path string, meta fileMeta) bool {
+	if len(f.In) > 0 {
+		rel := filepath.ToSlash(path)
+		if !slices.ContainsFunc(f.In, func(scope string) bool {
+			return strings.HasPrefix(rel, scope+"/")
+		}) {
+			return false
+		}
+	}
+
+	isDir := meta.Mode.IsDir()
+	switch f.Type {
+	case typeFile:
+		if isDir {
+			return false
+		}
+	case typeDir:
+		if !isDir {
+			return false
+		}
+	case typeSymlink:
+		if !meta.Symlink {
+			return false
+		}
+	}
+
+	// 目录没有大小和扩展名，有大小、扩展名或 MIME 条件时不保留
+	if f.MinSize != nil && (isDir || meta.Size < *f.MinSize) {
+		return false
+	}
+	if f.MaxSize != nil && (isDir || meta.Size > *f.MaxSize) {
+		return false
+	}
+	if f.After != nil && meta.ModTime.Before(*f.After) {
+		return false
+	}
+	if f.Before != nil && !meta.ModTime.Before(*f.Before) {
+		return false
+	}
+	if len(f.Ext) == 0 && len(f.MIME) == 0 {
+		return true
+	}
+	if isDir {
+		return false
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if len(f.Ext) > 0 && !slices.Contains(f.Ext, ext) {
+		return false
+	}
+	return len(f.MIME) == 0 || slices.ContainsFunc(f.MIME, func(category string) bool {
+		return mimeInCategory(meta.mime(ext), category)
+	})
+}
+
+// mimeWithin 判断属于 MIME 类别 a 的类型是否一定属于类别 b：完整的类型属于 b，
+// 或 document、archive 的每个前缀都在顶级类型 b 之下
+func mimeWithin(a, b string) bool {
+	if a == b {
+		return true
+	}
+	if strings.Contains(a, "/") {
+		return mimeInCategory(a, b)
+	}
+	prefixes, ok := mimeCategories[a]
+	return ok && slices.Contains(mimeTopLevels, b) &&
+		!slices.ContainsFunc(prefixes, func(prefix string) bool { return !strings.HasPrefix(prefix, b+"/") })
+}
+
+// mimeInCategory 判断 MIME 类型是否属于类别
+func mimeInCategory(typ, category string) bool {
+	if typ == "" {
+		return false
+	}
+	if strings.Contains(category, "/") {
+		return typ == category
+	}
+	if prefixes, ok := mimeCategories[category]; ok {
+		return slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(typ, prefix) })
+	}
+	return strings.HasPrefix(typ, category+"/")
+}
+
+// apply 返回只包含满足条件的候选的候选集，保持候选顺序，Index 仍用于报告索引状态和检测变化。
+// 候选集没有文件信息时逐个读取，无法读取的候选不保留
+func (f *MetaFilter) apply(set *candidateSet) *candidateSet {
+	filtered := &candidateSet{searchTarget: set.searchTarget, Stats: set.Stats, Index: set.Index}
+	for i, file := range set.Files {
+		var meta fileMeta
+		if set.Meta != nil {
+			meta = set.Meta[i]
+		} else {
+			var err error
+			if meta, err = statMeta(filepath.Join(set.Dir, file)); err != nil {
+				continue
+			}
+		}
+		if f.match(file, meta) {
+			filtered.Files = append(filtered.Files, file)
+			filtered.Meta = append(filtered.Meta, meta)
+		}
+	}
+	return filtered
+}
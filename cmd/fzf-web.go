@@ -1,40 +1,125 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
+	"syscall"
+	"time"
 
 	fzf "github.com/junegunn/fzf/src"
 )
 
 type SearchResult struct {
-	Path     string `json:"path"`
-	Filename string `json:"filename"`
-	Size     int64  `json:"size"`
+	Root      string    `json:"root,omitempty"` // 所属根目录名
+	Path      string    `json:"path"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	ModTime   time.Time `json:"modTime"`
+	Mode      string    `json:"mode"`            // 权限和类型，形如 -rw-r--r--
+	Owner     string    `json:"owner,omitempty"` // 属主，不支持的平台上为空
+	Group     string    `json:"group,omitempty"`
+	Ext       string    `json:"ext,omitempty"`       // 小写的扩展名，不含点
+	MIME      string    `json:"mime,omitempty"`      // 按扩展名判断，与排序和过滤使用的相同
+	Score     int       `json:"score"`               // fzf 的匹配得分，越高越相关
+	Positions []int     `json:"positions,omitempty"` // 路径中匹配字符的下标（按字符计），用于高亮
+	IsDir     bool      `json:"isDir,omitempty"`
+	Symlink   bool      `json:"symlink,omitempty"` // 条目本身是符号链接
+	Missing   bool      `json:"missing,omitempty"` // 校验时文件已不存在
+
+	// 校验时为扩展名无法判断 MIME 类型的普通文件读取内容判断的类型，不参与排序和过滤
+	ContentType string `json:"contentType,omitempty"`
+
+	set   *candidateSet // 所在的候选集，用于校验时重新读取文件信息和保存结果集
+	index int           // 在候选集中的下标
+	rank  rankKey       // 排序用的键，见 rankResults
 }
 
 type SearchRequest struct {
-	Query   string `json:"query"`
-	BaseDir string `json:"baseDir"`
-	UseAPI  bool   `json:"useAPI"` // 是否使用 fzf API
+	Query    string   `json:"query"`
+	Roots    []string `json:"roots,omitempty"`    // 要搜索的根目录名，可同时搜索多个
+	BaseDir  string   `json:"baseDir"`            // 兼容参数：直接指定允许范围内的目录
+	UseAPI   *bool    `json:"useAPI,omitempty"`   // 兼容参数：false 时使用 fzf 程序，options.engine 优先
+	NoIgnore *bool    `json:"noIgnore,omitempty"` // 是否不读取忽略文件，未设置时使用服务端默认值
+
+	// 以下遍历策略字段未设置时使用服务端默认值
+	Hidden   *bool    `json:"hidden,omitempty"`   // 是否包含隐藏文件
+	Exclude  []string `json:"exclude,omitempty"`  // 在默认规则之外额外排除的通配符
+	Include  []string `json:"include,omitempty"`  // 只保留匹配的候选，覆盖默认值
+	MaxDepth *int     `json:"maxDepth,omitempty"` // 最大遍历深度，0 表示不限制
+	Entries  string   `json:"entries,omitempty"`  // 候选条目类型: files、dirs 或 all
+	Follow   *bool    `json:"follow,omitempty"`   // 是否跟随符号链接，越界规则只能由服务端配置
+
+	Options MatchOptions `json:"options"`        // fzf 匹配选项，未设置的字段使用根目录和服务端默认值
+	Filter  MetaFilter   `json:"filter"`         // 按文件信息过滤候选，在匹配之前应用
+	Sort    string       `json:"sort,omitempty"` // 排序方式: score（默认）、name、size、mtime、ext、mime 或 owner
+	Desc    bool         `json:"desc,omitempty"` // 倒序排列
+
+	// 分页参数。翻页时带上第一页返回的 snapshotId，结果来自同一次搜索，不受索引变化影响
+	Limit      int    `json:"limit,omitempty"` // 每页结果数，默认 defaultPageSize
+	Offset     int    `json:"offset,omitempty"`
+	SnapshotID string `json:"snapshotId,omitempty"`
+
+	// 文件信息来自索引，可能落后于文件系统。为 true 时为返回的这一页结果重新读取文件信息
+	Verify bool `json:"verify,omitempty"`
+}
+
+// walkPolicy 将请求中的遍历字段合并到默认策略上
+func (req *SearchRequest) walkPolicy() WalkPolicy {
+	policy := defaultPolicy
+	if req.NoIgnore != nil {
+		policy.NoIgnore = *req.NoIgnore
+	}
+	if req.Hidden != nil {
+		policy.Hidden = *req.Hidden
+	}
+	if len(req.Exclude) > 0 {
+		policy.Exclude = slices.Concat(defaultPolicy.Exclude, req.Exclude)
+	}
+	if len(req.Include) > 0 {
+		policy.Include = req.Include
+	}
+	if req.MaxDepth != nil {
+		policy.MaxDepth = *req.MaxDepth
+	}
+	if req.Entries != "" {
+		policy.Entries = req.Entries
+	}
+	if req.Follow != nil {
+		policy.Follow = *req.Follow
+	}
+	return policy
 }
 
 type SearchResponse struct {
-	Results []SearchResult `json:"results"`
-	Error   string         `json:"error,omitempty"`
+	Results    []SearchResult `json:"results"`
+	Total      int            `json:"total"`              // 匹配的结果总数
+	Offset     int            `json:"offset"`             // 本页第一个结果的位置
+	SnapshotID string         `json:"snapshotId"`         // 结果集的标识，用于翻页
+	Indexes    []*IndexStatus `json:"indexes,omitempty"`  // 本次搜索所用索引的状态
+	Warnings   []string       `json:"warnings,omitempty"` // 遍历时跳过的条目及原因
+	Scanned    int            `json:"scanned"`            // 遍历访问的条目数
+	Skipped    int            `json:"skipped"`            // 无法读取而跳过的条目数
+	Truncated  bool           `json:"truncated"`          // 是否达到文件数上限
+	Stale      bool           `json:"stale,omitempty"`    // 部分索引来自快照，后台校对完成前结果可能过期
+	ElapsedMs  int64          `json:"elapsedMs"`          // 搜索耗时（毫秒）
+	Error      string         `json:"error,omitempty"`
 }
 
 var (
-	baseDir   string // 搜索目录
-	templates *template.Template
+	baseDir    string // 搜索目录
+	configPath string // 配置文件路径
+	templates  *template.Template
 )
 
 func init() {
@@ -43,25 +128,89 @@ func init() {
 }
 
 func main() {
-	// 获取当前目录作为默认搜索目录
-	currentDir, err := os.Getwd()
-	if err != nil {
-		log.Fatalf("无法获取当前目录: %v", err)
+	// 第一个参数不是选项时作为子命令，否则默认启动服务器
+	args := os.Args[1:]
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		runServe(args)
+	case "index":
+		runIndex(args)
+	case "search":
+		runSearch(args)
+	case "bench":
+		runBench(args)
+	default:
+		fmt.Fprintf(os.Stderr, "未知的子命令: %s\n", command)
+		fmt.Fprintf(os.Stderr, "用法: fzf-web [serve|index|search|bench] [参数]\n")
+		os.Exit(2)
+	}
+}
+
+// runServe 启动 HTTP 服务器
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	commonFlags(fs)
+	fs.Var(&allowFlags, "allow", "额外允许搜索的根目录，可重复指定")
+	fs.StringVar(&adminToken, "admin-token", "", "管理接口的访问令牌，为空时不启用管理接口")
+	fs.StringVar(&catalogDSN, "catalog-dsn", "", "将文件目录同步到 MySQL，例如 user:pass@tcp(127.0.0.1:3306)/fzfweb")
+	fs.BoolVar(&catalogHash, "catalog-hash", false, "在文件目录中记录文件内容的 SHA-256")
+	fs.DurationVar(&snapshotInterval, "snapshot-interval", 10*time.Minute, "定期保存索引快照的间隔")
+	parseFlags(fs, args)
+
+	// 只允许搜索和下载各根目录及 -allow 指定的目录
+	if err := initRoots(rootDefs(), allowFlags); err != nil {
+		log.Fatalf("无效的根目录: %v", err)
+	}
+
+	if err := initCatalog(); err != nil {
+		log.Fatalf("初始化文件目录失败: %v", err)
 	}
 
-	// 解析命令行参数
-	flag.StringVar(&baseDir, "d", currentDir, "指定搜索目录 (简写)")
-	flag.StringVar(&baseDir, "dir", currentDir, "指定搜索目录")
-	flag.Parse()
+	// 有根目录使用 fzf 程序时在启动时检查程序，请求中指定时在第一次使用时检查
+	for _, root := range listRoots() {
+		if defaultMatch.merge(root.Options).engine() == engineFzfBinary {
+			version, err := fzfBinary.check()
+			if err != nil {
+				log.Fatal(err)
+			}
+			log.Printf("使用 fzf 程序: %s %s", fzfPath, version)
+			break
+		}
+	}
 
-	// 检查目录是否存在
-	if _, err := os.Stat(baseDir); os.IsNotExist(err) {
-		log.Fatalf("指定的搜索目录不存在: %s", baseDir)
+	// 启动时构建各根目录的索引，之后由文件监听增量更新
+	for _, root := range listRoots() {
+		if _, err := getIndex(root.Path); err != nil {
+			log.Fatalf("构建索引失败 %s: %v", root.Name, err)
+		}
+	}
+
+	// 定期及退出时保存索引快照，下次启动时可以立即提供搜索
+	if snapshotDir != "" {
+		go saveSnapshotsEvery(snapshotInterval)
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+			log.Printf("正在保存索引快照...")
+			saveSnapshots()
+			os.Exit(0)
+		}()
 	}
 
 	// 设置静态文件路由
 	http.HandleFunc("/", handleIndex)
 	http.HandleFunc("/api/search", handleSearch)
+	http.HandleFunc("/api/search/stream", handleSearchStream)
+	http.HandleFunc("/api/search/session", handleSearchSession)
+	http.HandleFunc("/api/roots", handleRoots)
+	http.HandleFunc("/admin", handleAdmin)
+	http.HandleFunc("/api/admin/roots", handleAdminRoots)
 	http.HandleFunc("/api/download", handleDownload)
 
 	// 设置静态文件服务
@@ -69,17 +218,43 @@ func main() {
 
 	port := ":8080"
 	fmt.Printf("启动服务器在 http://localhost%s\n", port)
-	fmt.Printf("搜索目录: %s\n", baseDir)
-	fmt.Printf("使用 -d 或 --dir 参数可以指定其他搜索目录\n")
-	fmt.Printf("示例: go run fzf-web.go -d /path/to/search\n")
+	for _, root := range listRoots() {
+		fmt.Printf("搜索目录: %s = %s\n", root.Name, root.Path)
+	}
+	fmt.Printf("使用 -d 或 --dir 参数可以指定其他搜索目录，-root name=/path 可添加多个命名目录\n")
+	fmt.Printf("示例: go run ./cmd -d /path/to/search\n")
+	fmt.Printf("离线构建快照: go run ./cmd index -root name=/path -out name.snapshot\n")
 	log.Fatal(http.ListenAndServe(port, nil))
 }
 
 func handleIndex(w http.ResponseWriter, r *http.Request) {
-	data := map[string]interface{}{
-		"BaseDir": baseDir,
+	templates.ExecuteTemplate(w, "index", nil)
+}
+
+// RootInfo 是 /api/roots 返回的根目录及其索引状态
+type RootInfo struct {
+	Root
+	Index *IndexStatus `json:"index,omitempty"`
+}
+
+// rootInfos 返回所有根目录及其索引状态
+func rootInfos() []RootInfo {
+	var list []RootInfo
+	for _, root := range listRoots() {
+		info := RootInfo{Root: *root}
+		if idx := lookupIndex(root.Path); idx != nil {
+			info.Index = idx.Status()
+			info.Index.Name = root.Name
+		}
+		list = append(list, info)
 	}
-	templates.ExecuteTemplate(w, "index", data)
+	return list
+}
+
+// handleRoots 列出所有可搜索的根目录
+func handleRoots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rootInfos())
 }
 
 func handleSearch(w http.ResponseWriter, r *http.Request) {
@@ -96,178 +271,130 @@ func handleSearch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 使用提供的查询和目录
-	query := req.Query
-	searchDir := req.BaseDir
-	if searchDir == "" {
-		searchDir = baseDir
-	}
-
-	// 检查目录是否存在
-	if _, err := os.Stat(searchDir); os.IsNotExist(err) {
-		json.NewEncoder(w).Encode(SearchResponse{
-			Error: "目录不存在: " + searchDir,
-		})
-		return
+	// 翻页时从缓存的结果集中读取，不重新搜索
+	var (
+		snap *resultSnapshot
+		err  error
+	)
+	if req.SnapshotID != "" {
+		snap = resultSnapshots.get(req.SnapshotID)
+		if snap == nil {
+			w.WriteHeader(http.StatusGone)
+			json.NewEncoder(w).Encode(SearchResponse{
+				Error: "结果集已过期，请重新搜索",
+			})
+			return
+		}
+	} else {
+		s, err := req.search(r.Context())
+		if err != nil {
+			if r.Context().Err() != nil {
+				return // 客户端已断开或取消了请求
+			}
+			var serr *searchError
+			if errors.As(err, &serr) {
+				w.WriteHeader(serr.status)
+			}
+			json.NewEncoder(w).Encode(SearchResponse{
+				Error: err.Error(),
+			})
+			return
+		}
+		snap = resultSnapshots.add(s)
 	}
 
-	// 执行fzf搜索
-	var results []SearchResult
-	var err error
-
-	results, err = executeFzfSearchAPI(query, searchDir)
-	//if req.UseAPI {
-	//	// 使用 fzf API
-	//	results, err = executeFzfSearchAPI(query, searchDir)
-	//} else {
-	//	// 使用命令行方式
-	//	results, err = executeFzfSearch(query, searchDir)
-	//}
-
+	resp, err := snap.page(req.Offset, req.Limit)
 	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(SearchResponse{
-			Error: "搜索失败: " + err.Error(),
+			Error: err.Error(),
 		})
 		return
 	}
-
-	json.NewEncoder(w).Encode(SearchResponse{
-		Results: results,
-	})
+	resp.fill(req.Verify)
+	json.NewEncoder(w).Encode(resp)
 }
 
-func executeFzfSearch(query, searchDir string) ([]SearchResult, error) {
-	// 获取所有文件列表
-	files, err := getAllFiles(searchDir)
+// inputBuffer 是 fzf 输入通道的容量，搜索取消后最多还有这么多候选已送入 fzf
+const inputBuffer = 1024
+
+// fzfAPISearcher 使用 fzf 的 Go API 搜索，是默认的搜索引擎。
+// 搜索多个根目录时，每行候选以 "根目录名\t相对路径" 的形式送入同一个 fzf，只匹配路径部分；
+// 各根目录的结果不按 fzf 的顺序合并，而是匹配完成后按路径重新排序，见 fzfRanking。
+// sorted 为 false 时 fzf 不排序，匹配到的结果立即输出而不必等待全部候选匹配完成。
+//
+// fzf.Run 不能从外部中止，ctx 取消后停止向 fzf 输入候选并丢弃其余输出，不再为结果读取文件信息和计算得分；
+// fzf 对已输入的候选完成匹配后退出。不排序时 fzf 边读取边匹配，停止输入即停止匹配
+type fzfAPISearcher struct{}
+
+func (fzfAPISearcher) Search(ctx context.Context, query string, sets []*candidateSet, opts MatchOptions, sorted bool, emit func(SearchResult)) error {
+	fzfSorted, collect, done := fzfRanking(ctx, len(sets), sorted, emit)
+	args, err := fzfArgs(query, len(sets), opts, fzfSorted)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	// 构建fzf命令，使用filter模式（非交互）
-	cmd := exec.Command("fzf", "--filter", query, "--no-mouse", "--no-color", "--print-query")
-	cmd.Dir = searchDir
-
-	// 将文件列表写入fzf的标准输入
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return nil, err
-	}
-
-	// 启动命令
-	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("fzf命令启动失败: %v", err)
-	}
-
-	// 写入文件列表到fzf并关闭stdin
-	go func() {
-		defer stdin.Close()
-		for _, file := range files {
-			fmt.Fprintln(stdin, file)
+	out := newFzfOutput(query, sets, opts)
+	err = runFzfAPI(ctx, args, sets, func(line string) {
+		if result, ok := out.result(line); ok {
+			collect(result)
 		}
-	}()
-
-	// 等待命令完成并读取输出
-	output, err := cmd.Output()
+	})
 	if err != nil {
-		// fzf在没有匹配时返回非零退出码，这是正常的
-		if strings.Contains(err.Error(), "exit status 1") {
-			return []SearchResult{}, nil
-		}
-		return nil, fmt.Errorf("fzf执行失败: %v, output: %s", err, string(output))
-	}
-
-	// 解析输出
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	var results []SearchResult
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue // 跳过空行
-		}
-
-		// 跳过查询行（--print-query 会输出查询）
-		if line == query {
-			continue
-		}
-
-		fullPath := filepath.Join(searchDir, line)
-		info, err := os.Stat(fullPath)
-		if err != nil {
-			continue
-		}
-
-		results = append(results, SearchResult{
-			Path:     line,
-			Filename: filepath.Base(line),
-			Size:     info.Size(),
-		})
+		return err
 	}
+	return done()
+}
 
-	return results, nil
+func (fzfAPISearcher) Positions(query string, opts MatchOptions, paths []string) ([][]int, error) {
+	return fzfPositions(query, opts, paths), nil
 }
 
-// executeFzfSearchAPI 使用 fzf 的 Go API 进行搜索
-func executeFzfSearchAPI(query, searchDir string) ([]SearchResult, error) {
-	// 获取所有文件列表
-	files, err := getAllFiles(searchDir)
+// runFzfAPI 以 args 运行 fzf，输入全部候选，对查询行之后的每行输出调用 onLine。
+// ctx 取消后停止输入并不再调用 onLine，fzf 退出后返回。
+// 解析选项和 fzf 启动期间独占 fzfGlobals，输出查询行时已完成启动，之后转为共享，onLine 调用期间一直持有
+func runFzfAPI(ctx context.Context, args []string, sets []*candidateSet, onLine func(string)) error {
+	share, release := fzfGlobals.lock()
+	defer release()
+
+	options, err := fzf.ParseOptions(
+		false, // 不加载默认选项，避免冲突
+		args,
+	)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("fzf 选项解析失败: %v", err)
 	}
 
-	// 限制文件数量，避免处理过多文件
-	//if len(files) > 10000 {
-	//	files = files[:10000]
-	//}
+	total := 0
+	for _, set := range sets {
+		total += len(set.Files)
+	}
 
 	// 创建输入通道
-	inputChan := make(chan string, len(files))
+	inputChan := make(chan string, min(total, inputBuffer))
 
 	// 创建输出通道
 	outputChan := make(chan string, 100)
 
-	// 创建结果收集通道
-	resultsChan := make(chan []SearchResult, 1)
+	// 输出处理完成后关闭
+	done := make(chan struct{})
 
-	// 在 goroutine 中收集输出
+	// 在 goroutine 中处理输出。参数中有 --print-query，fzf 完成启动后先输出查询
 	go func() {
-		var results []SearchResult
+		defer close(done)
+		first := true
 		for s := range outputChan {
-			line := strings.TrimSpace(s)
-			if line == "" || line == query {
-				continue // 跳过空行和查询行
-			}
-
-			fullPath := filepath.Join(searchDir, line)
-			info, err := os.Stat(fullPath)
-			if err != nil {
+			if first {
+				first = false
+				share()
 				continue
 			}
-
-			results = append(results, SearchResult{
-				Path:     line,
-				Filename: filepath.Base(line),
-				Size:     info.Size(),
-			})
+			if ctx.Err() != nil {
+				continue // 已取消，只排空输出让 fzf 退出
+			}
+			onLine(s)
 		}
-		resultsChan <- results
 	}()
 
-	// 构建 fzf 选项
-	options, err := fzf.ParseOptions(
-		false, // 不加载默认选项，避免冲突
-		[]string{
-			"--filter", query,
-			"--no-mouse",
-			"--no-color",
-			"--print-query",
-			"--no-sort",
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("fzf 选项解析失败: %v", err)
-	}
-
 	// 设置输入和输出通道
 	options.Input = inputChan
 	options.Output = outputChan
@@ -287,103 +414,143 @@ func executeFzfSearchAPI(query, searchDir string) ([]SearchResult, error) {
 	// 发送文件列表到输入通道
 	go func() {
 		defer close(inputChan)
-		for _, file := range files {
-			inputChan <- file
-		}
+		feedCandidates(sets, func(line string) bool {
+			select {
+			case inputChan <- line:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
 	}()
 
-	// 等待结果收集完成
-	results := <-resultsChan
-	return results, nil
+	// 等待输出处理完成
+	<-done
+	return ctx.Err()
 }
 
-func getAllFiles(dir string) ([]string, error) {
-	var files []string
-	count := 0
-	maxFiles := 5000 // 限制最大文件数量
+// fzfArgs 返回 fzf 过滤模式的参数，API 和 fzf 程序共用。opts 是已校验的匹配选项。
+// 多个候选集时只匹配 "根目录名\t相对路径" 中的路径，fzf 的得分与单独匹配路径相同，tiebreak 则按整行计算，
+// sorted 应为 false，由 fzfRanking 排序
+func fzfArgs(query string, sets int, opts MatchOptions, sorted bool) ([]string, error) {
+	args := []string{
+		"--filter", query,
+		"--no-mouse",
+		"--no-color",
+		"--print-query",
+	}
+	if sets > 1 {
+		args = append(args, "--delimiter", "\t", "--nth", "2..")
+	}
+	if !sorted {
+		args = append(args, "--no-sort")
+	}
+	matchArgs, err := opts.args()
+	if err != nil {
+		return nil, err
+	}
+	return append(args, matchArgs...), nil
+}
 
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+// feedCandidates 依次将候选按 fzf 的输入格式交给 send，send 返回 false 时停止。
+// 输入的顺序就是结果中候选的顺序，多个候选集首尾相接
+func feedCandidates(sets []*candidateSet, send func(line string) bool) {
+	multi := len(sets) > 1
+	for _, set := range sets {
+		for _, file := range set.Files {
+			if multi {
+				file = set.Name + "\t" + file
+			}
+			if !send(file) {
+				return
+			}
 		}
+	}
+}
 
-		// 限制文件数量
-		if count >= maxFiles {
-			return filepath.SkipAll
-		}
+// fzfOutput 将 fzf 输出的行转换为结果。fzf 只输出匹配的行，得分按相同的查询语法和算法重新计算
+type fzfOutput struct {
+	sets    []*candidateSet
+	byName  map[string]*candidateSet
+	offsets map[*candidateSet]int // 候选集第一个候选在全部候选中的顺序
+	matcher *queryMatcher
+}
 
-		// 跳过隐藏文件和目录
-		if strings.HasPrefix(filepath.Base(path), ".") {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
+func newFzfOutput(query string, sets []*candidateSet, opts MatchOptions) *fzfOutput {
+	out := &fzfOutput{
+		sets:    sets,
+		byName:  map[string]*candidateSet{},
+		offsets: map[*candidateSet]int{},
+		matcher: newQueryMatcher(query, opts),
+	}
+	n := 0
+	for _, set := range sets {
+		out.byName[set.Name] = set
+		out.offsets[set] = n
+		n += len(set.Files)
+	}
+	return out
+}
 
-		// 跳过一些常见的系统目录
-		if info.IsDir() {
-			baseName := filepath.Base(path)
-			if baseName == "node_modules" || baseName == ".git" || baseName == "__pycache__" {
-				return filepath.SkipDir
-			}
-			return nil
-		}
+// result 解析查询行之后的一行输出，跳过空行和无法读取的文件
+func (out *fzfOutput) result(s string) (SearchResult, bool) {
+	line := strings.TrimSpace(s)
+	if line == "" {
+		return SearchResult{}, false
+	}
 
-		// 只包含文件，不包含目录
-		if !info.IsDir() {
-			// 返回相对路径
-			relPath, err := filepath.Rel(dir, path)
-			if err != nil {
-				return err
-			}
-			files = append(files, relPath)
-			count++
+	set := out.sets[0]
+	if len(out.sets) > 1 {
+		name, path, ok := strings.Cut(line, "\t")
+		if !ok || out.byName[name] == nil {
+			return SearchResult{}, false
 		}
+		set, line = out.byName[name], path
+	}
+	i := set.find(line)
+	if i < 0 {
+		return SearchResult{}, false
+	}
 
-		return nil
-	})
-
-	return files, err
+	score, positions, points := out.matcher.Match(line)
+	result, ok := set.result(i, score, positions)
+	result.rank = rankKey{points, out.offsets[set] + i}
+	return result, ok
 }
 
 func handleDownload(w http.ResponseWriter, r *http.Request) {
 	filePath := r.URL.Query().Get("file")
 	searchDir := r.URL.Query().Get("dir") // 获取搜索目录参数
 
+	// 指定根目录名时以该根目录为基准
+	if name := r.URL.Query().Get("root"); name != "" {
+		root := findRoot(name)
+		if root == nil {
+			http.Error(w, "Access denied", http.StatusForbidden)
+			return
+		}
+		searchDir = root.Path
+	}
+
 	if filePath == "" {
 		http.Error(w, "Missing file parameter", http.StatusBadRequest)
 		return
 	}
 
-	// 如果没有指定搜索目录，使用默认的 baseDir
-	if searchDir == "" {
-		searchDir = baseDir
-	}
-
-	// 构建完整路径
-	fullPath := filepath.Join(searchDir, filePath)
-
-	// 安全检查：确保文件在指定目录内
-	absPath, err := filepath.Abs(fullPath)
-	if err != nil {
-		http.Error(w, "Invalid file path", http.StatusBadRequest)
+	// 解析符号链接后检查文件是否位于允许的根目录之下
+	fullPath, err := resolveAllowedFile(searchDir, filePath)
+	if errors.Is(err, errForbidden) {
+		http.Error(w, "Access denied", http.StatusForbidden)
 		return
 	}
-
-	absSearchDir, err := filepath.Abs(searchDir)
 	if err != nil {
-		http.Error(w, "Invalid search directory", http.StatusInternalServerError)
+		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 
-	if !strings.HasPrefix(absPath, absSearchDir) {
-		http.Error(w, "Access denied", http.StatusForbidden)
-		return
-	}
-
-	// 检查文件是否存在
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-		http.Error(w, "File not found", http.StatusNotFound)
+	// 不提供目录下载
+	if info, err := os.Stat(fullPath); err != nil || info.IsDir() {
+		http.Error(w, "Not a file", http.StatusBadRequest)
 		return
 	}
 
@@ -503,6 +670,34 @@ const htmlTemplate = `
             transform: none;
         }
         
+        .roots-input {
+            display: flex;
+            flex-wrap: wrap;
+            gap: 12px;
+            padding: 12px 0;
+            color: #555;
+        }
+        
+        .root-tag {
+            display: inline-block;
+            background: #e8f4fe;
+            color: #1c7ed6;
+            border-radius: 4px;
+            padding: 2px 8px;
+            margin-right: 8px;
+            font-size: 0.8rem;
+            font-weight: 500;
+        }
+        
+        .search-options {
+            display: flex;
+            flex-wrap: wrap;
+            gap: 20px;
+            margin-bottom: 10px;
+            color: #555;
+            font-size: 0.95rem;
+        }
+        
         .results-section {
             padding: 0 40px 40px;
         }
@@ -542,6 +737,10 @@ const htmlTemplate = `
             100% { transform: rotate(360deg); }
         }
         
+        .load-more-btn {
+            margin: 20px auto 0;
+        }
+        
         .results-list {
             display: grid;
             gap: 15px;
@@ -586,6 +785,26 @@ const htmlTemplate = `
             word-break: break-all;
         }
         
+        .result-meta {
+            color: #999;
+            font-size: 0.8rem;
+            margin-top: 4px;
+        }
+        
+        .result-item.missing {
+            opacity: 0.6;
+        }
+        
+        .result-item.missing .result-filename {
+            text-decoration: line-through;
+        }
+        
+        .result-item mark {
+            background: #fff3bf;
+            color: inherit;
+            font-weight: 600;
+        }
+        
         .download-btn {
             background: #28a745;
             color: white;
@@ -650,17 +869,107 @@ const htmlTemplate = `
         <div class="search-section">
             <form class="search-form" id="searchForm">
                 <div class="input-group">
-                    <label for="baseDirInput">搜索目录</label>
-                    <input type="text" id="baseDirInput" class="search-input" value="{{.BaseDir}}" placeholder="输入搜索目录路径...">
+                    <label>搜索目录</label>
+                    <div id="rootsInput" class="roots-input"></div>
                 </div>
                 <div class="input-group">
                     <label for="searchInput">搜索关键词</label>
-                    <input type="text" id="searchInput" class="search-input" placeholder="输入搜索关键词..." required>
+                    <input type="text" id="searchInput" class="search-input" placeholder="输入搜索关键词，如 report ext:xlsx size:&gt;1M modified:&lt;7d in:finance/" required>
                 </div>
                 <button type="submit" class="search-btn" id="searchBtn">
                     <span id="searchBtnText">搜索</span>
                 </button>
             </form>
+            <div class="search-options">
+                <label><input type="checkbox" id="noIgnoreInput"> 不使用 .gitignore / .ignore / .fdignore 规则</label>
+                <label><input type="checkbox" id="hiddenInput"> 包含隐藏文件</label>
+                <label>类型
+                    <select id="entriesInput">
+                        <option value="">默认</option>
+                        <option value="files">文件</option>
+                        <option value="dirs">目录</option>
+                        <option value="all">文件和目录</option>
+                    </select>
+                </label>
+                <label>最大深度 <input type="number" id="maxDepthInput" min="0" placeholder="不限" style="width: 70px;"></label>
+                <label>排除 <input type="text" id="excludeInput" placeholder="build/, *.log"></label>
+                <label><input type="checkbox" id="followInput"> 跟随符号链接</label>
+            </div>
+            <div class="search-options">
+                <label>引擎
+                    <select id="engineInput">
+                        <option value="">默认</option>
+                        <option value="fzf">fzf</option>
+                        <option value="native">内置并行匹配</option>
+                        <option value="fzf-bin">fzf 程序</option>
+                        <option value="substring">子串</option>
+                        <option value="regex">正则</option>
+                    </select>
+                </label>
+                <label>匹配
+                    <select id="exactInput">
+                        <option value="">默认</option>
+                        <option value="false">模糊</option>
+                        <option value="true">精确</option>
+                    </select>
+                </label>
+                <label>大小写
+                    <select id="caseInput">
+                        <option value="">默认</option>
+                        <option value="smart">智能</option>
+                        <option value="ignore">忽略</option>
+                        <option value="respect">区分</option>
+                    </select>
+                </label>
+                <label>算法
+                    <select id="algoInput">
+                        <option value="">默认</option>
+                        <option value="v2">v2</option>
+                        <option value="v1">v1</option>
+                    </select>
+                </label>
+                <label>tiebreak <input type="text" id="tiebreakInput" placeholder="length,pathname"></label>
+                <label><input type="checkbox" id="literalInput"> 不忽略重音符号</label>
+                <label>排序
+                    <select id="sortInput">
+                        <option value="">相关度</option>
+                        <option value="name">名称</option>
+                        <option value="size">大小</option>
+                        <option value="mtime">修改时间</option>
+                        <option value="ext">扩展名</option>
+                        <option value="mime">文件类型</option>
+                        <option value="owner">属主</option>
+                    </select>
+                </label>
+                <label><input type="checkbox" id="descInput"> 倒序</label>
+                <label><input type="checkbox" id="verifyInput"> 校验文件信息</label>
+            </div>
+            <div class="search-options">
+                <label>大小 <input type="text" id="minSizeInput" placeholder="最小，如 10M" style="width: 90px;"></label>
+                <label>至 <input type="text" id="maxSizeInput" placeholder="最大" style="width: 90px;"></label>
+                <label>修改时间 <input type="date" id="afterInput"></label>
+                <label>至 <input type="date" id="beforeInput"></label>
+                <label>扩展名 <input type="text" id="extInput" placeholder="pdf, xlsx"></label>
+                <label>类型
+                    <select id="typeInput">
+                        <option value="">不限</option>
+                        <option value="file">文件</option>
+                        <option value="dir">目录</option>
+                        <option value="symlink">符号链接</option>
+                    </select>
+                </label>
+                <label>文件类型
+                    <select id="mimeInput">
+                        <option value="">不限</option>
+                        <option value="document">文档</option>
+                        <option value="image">图片</option>
+                        <option value="video">视频</option>
+                        <option value="audio">音频</option>
+                        <option value="text">文本</option>
+                        <option value="archive">压缩包</option>
+                    </select>
+                </label>
+            </div>
         </div>
         
         <div class="results-section">
@@ -670,6 +979,7 @@ const htmlTemplate = `
                     <div class="results-count" id="resultsCount"></div>
                 </div>
                 <div id="resultsList" class="results-list"></div>
+                <button type="button" class="search-btn load-more-btn" id="loadMoreBtn" style="display: none;">加载更多</button>
             </div>
             
             <div id="loading" class="loading" style="display: none;">
@@ -684,7 +994,29 @@ const htmlTemplate = `
     <script>
         const searchForm = document.getElementById('searchForm');
         const searchInput = document.getElementById('searchInput');
-        const baseDirInput = document.getElementById('baseDirInput');
+        const rootsInput = document.getElementById('rootsInput');
+        const noIgnoreInput = document.getElementById('noIgnoreInput');
+        const hiddenInput = document.getElementById('hiddenInput');
+        const entriesInput = document.getElementById('entriesInput');
+        const maxDepthInput = document.getElementById('maxDepthInput');
+        const excludeInput = document.getElementById('excludeInput');
+        const followInput = document.getElementById('followInput');
+        const exactInput = document.getElementById('exactInput');
+        const caseInput = document.getElementById('caseInput');
+        const engineInput = document.getElementById('engineInput');
+        const algoInput = document.getElementById('algoInput');
+        const tiebreakInput = document.getElementById('tiebreakInput');
+        const literalInput = document.getElementById('literalInput');
+        const sortInput = document.getElementById('sortInput');
+        const descInput = document.getElementById('descInput');
+        const verifyInput = document.getElementById('verifyInput');
+        const minSizeInput = document.getElementById('minSizeInput');
+        const maxSizeInput = document.getElementById('maxSizeInput');
+        const afterInput = document.getElementById('afterInput');
+        const beforeInput = document.getElementById('beforeInput');
+        const extInput = document.getElementById('extInput');
+        const typeInput = document.getElementById('typeInput');
+        const mimeInput = document.getElementById('mimeInput');
         const searchBtn = document.getElementById('searchBtn');
         const searchBtnText = document.getElementById('searchBtnText');
         const resultsContainer = document.getElementById('resultsContainer');
@@ -692,53 +1024,314 @@ const htmlTemplate = `
         const resultsCount = document.getElementById('resultsCount');
         const loading = document.getElementById('loading');
         const error = document.getElementById('error');
+        const loadMoreBtn = document.getElementById('loadMoreBtn');
+        
+        // 每次请求一页，翻页时使用第一页返回的 snapshotId
+        const pageSize = 100;
+        let shownResults = [];
+        
+        // 输入停止 debounceMs 毫秒后自动搜索，searchController 用于取消上一次搜索。
+        // 搜索会话可用时服务端会取消被替代的查询，等待时间更短
+        const debounceMs = 250;
+        const sessionDebounceMs = 50;
+        let debounceTimer = null;
+        let searchController = null;
+        
+        // 搜索会话的 WebSocket 连接，断开后改用流式搜索。sessionSeq 是最近一次请求的序号，
+        // sessionPaging 表示最近一次请求是翻页
+        let session = null;
+        let sessionSeq = 0;
+        let sessionPaging = false;
+        let lastSummary = null;
+
+        // 加载可搜索的根目录，默认只选中第一个
+        async function loadRoots() {
+            try {
+                const response = await fetch('/api/roots');
+                const roots = await response.json();
+                // 根目录名和路径通过属性赋值，不拼接到 HTML 中
+                rootsInput.replaceChildren(...(roots || []).map(function(root, i) {
+                    const label = document.createElement('label');
+                    label.title = root.path;
+                    const input = document.createElement('input');
+                    input.type = 'checkbox';
+                    input.name = 'root';
+                    input.value = root.name;
+                    // 已暂停的根目录不能被选中
+                    input.disabled = !!root.paused;
+                    input.checked = !root.paused && i === 0;
+                    label.append(input, ' ' + root.name + (root.paused ? '（已暂停）' : ''));
+                    return label;
+                }));
+            } catch (err) {
+                showError('加载搜索目录失败: ' + err.message);
+            }
+        }
 
-        searchForm.addEventListener('submit', async (e) => {
+        function selectedRoots() {
+            return Array.from(rootsInput.querySelectorAll('input[name="root"]:checked')).map(function(input) {
+                return input.value;
+            });
+        }
+
+        loadRoots();
+
+        searchForm.addEventListener('submit', (e) => {
             e.preventDefault();
-            
+            clearTimeout(debounceTimer);
+            runSearch(false);
+        });
+
+        // 输入时自动搜索，停止输入一段时间后才发出请求
+        searchInput.addEventListener('input', () => {
+            clearTimeout(debounceTimer);
+            debounceTimer = setTimeout(function() { runSearch(true); }, session ? sessionDebounceMs : debounceMs);
+        });
+
+        function openSession() {
+            const ws = new WebSocket((location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/api/search/session');
+            ws.onopen = function() {
+                session = ws;
+            };
+            ws.onclose = function() {
+                if (session === ws) {
+                    session = null;
+                }
+            };
+            ws.onmessage = function(e) {
+                const data = JSON.parse(e.data);
+                if (data.seq !== sessionSeq) {
+                    return; // 已被更新的请求替代
+                }
+                setLoading(false);
+                loadMoreBtn.disabled = false;
+                if (data.error) {
+                    showError(data.error);
+                    return;
+                }
+                hideError();
+                shownResults = sessionPaging ? shownResults.concat(data.results || []) : (data.results || []);
+                showResults(shownResults, data);
+            };
+        }
+        openSession();
+
+        // runSearch 执行搜索并取消仍在进行的上一次搜索，服务端随之停止匹配。
+        // typing 为 true 时是输入触发的搜索，空查询只清空结果，旧结果保留到新结果到达
+        async function runSearch(typing) {
             const query = searchInput.value.trim();
-            const baseDir = baseDirInput.value.trim() || '.';
+            const roots = selectedRoots();
             
+            if (searchController) {
+                searchController.abort();
+                searchController = null;
+            }
+            sessionSeq++; // 忽略会话中尚未返回的结果
             if (!query) {
-                showError('请输入搜索关键词');
+                if (typing) {
+                    setLoading(false);
+                    hideResults();
+                } else {
+                    showError('请输入搜索关键词');
+                }
+                return;
+            }
+            if (roots.length === 0) {
+                showError('请至少选择一个搜索目录');
                 return;
             }
             
+            // 输入时优先使用搜索会话，服务端复用候选并取消被替代的查询
+            if (typing && session) {
+                setLoading(true);
+                hideError();
+                sessionPaging = false;
+                session.send(JSON.stringify(Object.assign({ type: 'query', seq: sessionSeq }, buildRequest(query, roots))));
+                return;
+            }
+            
+            const controller = new AbortController();
+            searchController = controller;
+            
             // 显示加载状态
             setLoading(true);
             hideError();
-            hideResults();
+            if (!typing) {
+                hideResults();
+            }
             
             try {
-                const response = await fetch('/api/search', {
-                    method: 'POST',
-                    headers: {
-                        'Content-Type': 'application/json',
-                    },
-                    body: JSON.stringify({
-                        query: query,
-                        baseDir: baseDir
-                    })
+                // 结果边到达边显示，汇总到达后换成排序后的第一页
+                let streamed = 0;
+                const data = await streamSearch(buildRequest(query, roots), controller.signal, function(result) {
+                    streamed++;
+                    if (streamed === 1) {
+                        resultsContainer.style.display = 'block';
+                        resultsList.innerHTML = '';
+                    }
+                    if (streamed <= pageSize) {
+                        resultsList.insertAdjacentHTML('beforeend', renderResult(result));
+                    }
+                    resultsCount.textContent = '已找到 ' + streamed + ' 个结果，搜索中...';
                 });
                 
-                if (!response.ok) {
-                    throw new Error('HTTP ' + response.status + ': ' + response.statusText);
-                }
-                
-                const data = await response.json();
-                
                 if (data.error) {
                     showError(data.error);
                 } else {
-                    showResults(data.results);
+                    shownResults = data.results || [];
+                    showResults(shownResults, data);
                 }
             } catch (err) {
+                // 被新的搜索取消时由新的搜索更新界面
+                if (controller.signal.aborted) {
+                    return;
+                }
                 showError('搜索请求失败: ' + err.message);
             } finally {
-                setLoading(false);
+                if (searchController === controller) {
+                    searchController = null;
+                    setLoading(false);
+                }
+            }
+        }
+
+        // 从同一个结果集中加载下一页
+        loadMoreBtn.addEventListener('click', async () => {
+            loadMoreBtn.disabled = true;
+            
+            // 会话的结果没有 snapshotId，在会话中翻页
+            if (!lastSummary.snapshotId) {
+                if (!session) {
+                    showError('搜索会话已断开，请重新搜索');
+                    loadMoreBtn.disabled = false;
+                    return;
+                }
+                sessionPaging = true;
+                session.send(JSON.stringify({ type: 'page', seq: ++sessionSeq, offset: shownResults.length, limit: pageSize, verify: verifyInput.checked || undefined }));
+                return;
+            }
+            try {
+                const data = await postSearch({
+                    snapshotId: lastSummary.snapshotId,
+                    offset: shownResults.length,
+                    limit: pageSize,
+                    verify: verifyInput.checked || undefined
+                });
+                if (data.error) {
+                    showError(data.error);
+                } else {
+                    shownResults = shownResults.concat(data.results || []);
+                    showResults(shownResults, data);
+                }
+            } catch (err) {
+                showError('加载更多结果失败: ' + err.message);
+            } finally {
+                loadMoreBtn.disabled = false;
             }
         });
 
+        // buildRequest 根据界面上的选项生成搜索请求
+        function buildRequest(query, roots) {
+            return {
+                query: query,
+                roots: roots,
+                // 未设置的选项不发送，使用服务端默认设置
+                noIgnore: noIgnoreInput.checked || undefined,
+                hidden: hiddenInput.checked || undefined,
+                entries: entriesInput.value || undefined,
+                maxDepth: maxDepthInput.value === '' ? undefined : parseInt(maxDepthInput.value, 10),
+                exclude: splitList(excludeInput.value),
+                follow: followInput.checked || undefined,
+                sort: sortInput.value || undefined,
+                desc: descInput.checked || undefined,
+                verify: verifyInput.checked || undefined,
+                filter: {
+                    minSize: parseSize(minSizeInput.value),
+                    maxSize: parseSize(maxSizeInput.value),
+                    // 日期按本地时间计，结束日期当天也包含在内
+                    after: afterInput.value ? new Date(afterInput.value + 'T00:00').toISOString() : undefined,
+                    before: beforeInput.value ? new Date(new Date(beforeInput.value + 'T00:00').getTime() + 86400000).toISOString() : undefined,
+                    ext: splitList(extInput.value),
+                    type: typeInput.value || undefined,
+                    mime: mimeInput.value ? [mimeInput.value] : undefined
+                },
+                options: {
+                    exact: exactInput.value === '' ? undefined : exactInput.value === 'true',
+                    case: caseInput.value || undefined,
+                    algo: algoInput.value || undefined,
+                    tiebreak: tiebreakInput.value.replace(/\s+/g, '') || undefined,
+                    literal: literalInput.checked || undefined,
+                    engine: engineInput.value || undefined
+                },
+                limit: pageSize
+            };
+        }
+
+        // 流式搜索：每个结果到达时调用 onResult，返回最后的汇总。signal 取消时请求中止
+        async function streamSearch(body, signal, onResult) {
+            const response = await fetch('/api/search/stream', {
+                method: 'POST',
+                signal: signal,
+                headers: {
+                    'Content-Type': 'application/json',
+                    'Accept': 'text/event-stream'
+                },
+                body: JSON.stringify(body)
+            });
+            
+            // 按 SSE 格式解析，事件之间以空行分隔
+            const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+            let buffer = '';
+            let summary = null;
+            for (;;) {
+                const { value, done } = await reader.read();
+                if (done) {
+                    break;
+                }
+                buffer += value;
+                let end;
+                while ((end = buffer.indexOf('\n\n')) >= 0) {
+                    const frame = buffer.slice(0, end);
+                    buffer = buffer.slice(end + 2);
+                    let event = 'message';
+                    let data = '';
+                    frame.split('\n').forEach(function(line) {
+                        if (line.startsWith('event: ')) {
+                            event = line.slice(7);
+                        } else if (line.startsWith('data: ')) {
+                            data += line.slice(6);
+                        }
+                    });
+                    if (event === 'result') {
+                        onResult(JSON.parse(data));
+                    } else {
+                        summary = JSON.parse(data);
+                    }
+                }
+            }
+            if (!summary) {
+                throw new Error('HTTP ' + response.status + ': 响应不完整');
+            }
+            return summary;
+        }
+
+        async function postSearch(body) {
+            const response = await fetch('/api/search', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                },
+                body: JSON.stringify(body)
+            });
+            
+            // 403 等错误响应也可能带有 JSON 格式的错误信息
+            const data = await response.json().catch(function() { return null; });
+            if (!response.ok && !(data && data.error)) {
+                throw new Error('HTTP ' + response.status + ': ' + response.statusText);
+            }
+            return data;
+        }
+
         function setLoading(isLoading) {
             if (isLoading) {
                 searchBtn.disabled = true;
@@ -751,8 +1344,10 @@ const htmlTemplate = `
             }
         }
 
-        function showResults(results) {
+        function showResults(results, summary) {
             resultsContainer.style.display = 'block';
+            lastSummary = summary;
+            loadMoreBtn.style.display = results && results.length < summary.total ? 'block' : 'none';
             
             // 检查 results 是否为 null 或 undefined
             if (!results || !Array.isArray(results)) {
@@ -761,28 +1356,111 @@ const htmlTemplate = `
                 return;
             }
             
+            resultsCount.textContent = (results.length < summary.total ? '显示 ' + results.length + ' / ' : '') + summary.total + ' 个结果' + summaryNote(summary);
+            resultsCount.title = (summary.warnings || []).join('\n');
+            
             if (results.length === 0) {
                 resultsList.innerHTML = '<div class="empty-state"><h3>没有找到匹配的文件</h3></div>';
-                resultsCount.textContent = '0 个结果';
                 return;
             }
             
-            resultsCount.textContent = results.length + ' 个结果';
+            resultsList.innerHTML = results.map(renderResult).join('');
+        }
+
+        // renderResult 生成一个结果的 HTML
+        function renderResult(result) {
+            // 检查 result 对象是否有效
+            if (!result || typeof result !== 'object') {
+                return '';
+            }
             
-            resultsList.innerHTML = results.map(function(result) {
-                // 检查 result 对象是否有效
-                if (!result || typeof result !== 'object') {
-                    return '';
-                }
-                
-                const filename = result.filename || '未知文件';
-                const path = result.path || '';
-                const size = result.size || 0;
-                
-                return '<div class="result-item"><div class="result-header"><div class="result-filename">' + escapeHtml(filename) + '</div><div class="result-size">' + formatFileSize(size) + '</div></div><div class="result-path">' + escapeHtml(path) + '</div><button class="download-btn" onclick="downloadFile(\'' + escapeHtml(path) + '\')">下载文件</button></div>';
+            const filename = result.filename || '未知文件';
+            const path = result.path || '';
+            const size = result.size || 0;
+            const link = result.symlink ? ' 🔗' : '';
+            const root = result.root || '';
+            const tag = root ? '<span class="root-tag">' + escapeHtml(root) + '</span>' : '';
+            const score = result.score ? ' · 得分 ' + result.score : '';
+            
+            // 校验时已不存在的文件只显示，不能下载
+            if (result.missing) {
+                return '<div class="result-item missing"><div class="result-header"><div class="result-filename">' + escapeHtml(filename) + link + '</div><div class="result-size">已删除' + score + '</div></div><div class="result-path">' + tag + escapeHtml(path) + '</div></div>';
+            }
+            
+            // 匹配位置按路径中的字符计，文件名是路径的最后一段
+            const positions = new Set(result.positions || []);
+            const nameOffset = Array.from(path).length - Array.from(filename).length;
+            const nameHtml = highlight(filename, positions, nameOffset);
+            const pathHtml = highlight(path, positions, 0);
+            
+            // 目录没有大小，也不能下载
+            if (result.isDir) {
+                return '<div class="result-item"><div class="result-header"><div class="result-filename">📁 ' + nameHtml + link + '</div><div class="result-size">目录' + score + '</div></div><div class="result-path">' + tag + pathHtml + '</div>' + resultMeta(result) + '</div>';
+            }
+            
+            return '<div class="result-item"><div class="result-header"><div class="result-filename">' + nameHtml + link + '</div><div class="result-size">' + formatFileSize(size) + score + '</div></div><div class="result-path">' + tag + pathHtml + '</div>' + resultMeta(result) + '<button class="download-btn" data-root="' + escapeHtml(root) + '" data-path="' + escapeHtml(path) + '">下载文件</button></div>';
+        }
+
+        // 修改时间、权限、属主和文件类型
+        function resultMeta(result) {
+            const parts = [];
+            const modTime = new Date(result.modTime);
+            if (result.modTime && modTime.getFullYear() > 1) {
+                parts.push('修改于 ' + modTime.toLocaleString());
+            }
+            if (result.mode) {
+                parts.push(result.mode);
+            }
+            if (result.owner || result.group) {
+                parts.push((result.owner || '?') + ':' + (result.group || '?'));
+            }
+            if (result.mime) {
+                parts.push(result.mime);
+            }
+            if (result.contentType) {
+                parts.push('内容 ' + result.contentType);
+            }
+            return parts.length > 0 ? '<div class="result-meta">' + escapeHtml(parts.join(' · ')) + '</div>' : '';
+        }
+
+        // 高亮匹配的字符，offset 是 text 第一个字符在路径中的下标
+        function highlight(text, positions, offset) {
+            return Array.from(text).map(function(ch, i) {
+                return positions.has(offset + i) ? '<mark>' + escapeHtml(ch) + '</mark>' : escapeHtml(ch);
             }).join('');
         }
 
+        // 将 10M、1.5G 这样的大小转换为字节数，为空时返回 undefined
+        function parseSize(text) {
+            const m = text.trim().match(/^(\d+(?:\.\d+)?)\s*([KMGT]?)i?B?$/i);
+            if (!m) {
+                return undefined;
+            }
+            const units = { '': 1, K: 1024, M: 1024 * 1024, G: 1024 * 1024 * 1024, T: 1024 * 1024 * 1024 * 1024 };
+            return Math.round(parseFloat(m[1]) * units[m[2].toUpperCase()]);
+        }
+
+        // 将逗号分隔的输入拆分为列表，为空时返回 undefined
+        function splitList(text) {
+            const items = text.split(',').map(function(item) { return item.trim(); }).filter(Boolean);
+            return items.length > 0 ? items : undefined;
+        }
+
+        // 说明哪些部分没有被搜索到
+        function summaryNote(summary) {
+            let note = '';
+            if (summary.truncated) {
+                note += '（已达到文件数上限，结果可能不完整）';
+            }
+            if (summary.stale) {
+                note += '（索引来自快照，后台校对完成前结果可能过期）';
+            }
+            if (summary.skipped > 0) {
+                note += '（' + summary.skipped + ' 个条目无法读取，已跳过）';
+            }
+            return note;
+        }
+
         function hideResults() {
             resultsContainer.style.display = 'none';
         }
@@ -796,9 +1474,16 @@ const htmlTemplate = `
             error.style.display = 'none';
         }
 
-        function downloadFile(filePath) {
-            const searchDir = baseDirInput.value.trim() || '.';
-            const url = '/api/download?file=' + encodeURIComponent(filePath) + '&dir=' + encodeURIComponent(searchDir);
+        // 下载按钮的根目录和路径放在 data-* 属性中，由结果列表统一处理点击
+        resultsList.addEventListener('click', (e) => {
+            const btn = e.target.closest('.download-btn');
+            if (btn) {
+                downloadFile(btn.dataset.root, btn.dataset.path);
+            }
+        });
+
+        function downloadFile(root, filePath) {
+            const url = '/api/download?file=' + encodeURIComponent(filePath) + '&root=' + encodeURIComponent(root);
             const link = document.createElement('a');
             link.href = url;
             link.download = '';
@@ -807,10 +1492,11 @@ const htmlTemplate = `
             document.body.removeChild(link);
         }
 
+        // escapeHtml 转义文本，结果也可以放在双引号或单引号括起的属性值中
         function escapeHtml(text) {
-            const div = document.createElement('div');
-            div.textContent = text;
-            return div.innerHTML;
+            return String(text).replace(/[&<>"']/g, function(ch) {
+                return {'&': '&amp;', '<': '&lt;', '>': '&gt;', '"': '&quot;', "'": '&#39;'}[ch];
+            });
         }
 
         function formatFileSize(bytes) {
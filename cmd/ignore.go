@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ignoreFileNames 是每个目录下会读取的忽略文件，
+// 与 fd 一致，后读取的文件优先级更高
+var ignoreFileNames = []string{".gitignore", ".ignore", ".fdignore"}
+
+// isIgnoreFile 判断文件名是否为忽略规则文件
+func isIgnoreFile(name string) bool {
+	for _, n := range ignoreFileNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+// ignorePattern 是忽略文件中的一条规则
+type ignorePattern struct {
+	re      *regexp.Regexp // 匹配相对于忽略文件所在目录的路径（以 / 分隔）
+	negate  bool           // 以 ! 开头，重新包含之前被忽略的路径
+	dirOnly bool           // 以 / 结尾，只匹配目录
+}
+
+// ignoreDir 是某个目录下所有忽略文件的规则，并链接到父目录的规则
+type ignoreDir struct {
+	parent   *ignoreDir
+	dir      string
+	patterns []ignorePattern
+}
+
+// ignoreTree 按需读取并缓存 root 之下各目录的忽略规则，可被并发使用
+type ignoreTree struct {
+	root string
+
+	mu   sync.Mutex
+	dirs map[string]*ignoreDir
+}
+
+func newIgnoreTree(root string) *ignoreTree {
+	return &ignoreTree{
+		root: filepath.Clean(root),
+		dirs: map[string]*ignoreDir{},
+	}
+}
+
+// Ignored 判断 path 是否被其所在目录及各级父目录中的忽略文件排除
+func (t *ignoreTree) Ignored(path string, isDir bool) bool {
+	path = filepath.Clean(path)
+	if path == t.root {
+		return false
+	}
+	return t.dir(filepath.Dir(path)).match(path, isDir)
+}
+
+// dir 返回目录的规则链，第一次访问时从磁盘读取
+func (t *ignoreTree) dir(dir string) *ignoreDir {
+	t.mu.Lock()
+	d, ok := t.dirs[dir]
+	t.mu.Unlock()
+	if ok {
+		return d
+	}
+
+	var parent *ignoreDir
+	if dir != t.root {
+		if up := filepath.Dir(dir); up != dir && strings.HasPrefix(dir, t.root) {
+			parent = t.dir(up)
+		}
+	}
+	d = &ignoreDir{
+		parent:   parent,
+		dir:      dir,
+		patterns: loadIgnorePatterns(dir),
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if existing, ok := t.dirs[dir]; ok {
+		return existing
+	}
+	t.dirs[dir] = d
+	return d
+}
+
+// match 从最深的目录开始查找，同一文件中后出现的规则优先，
+// 第一个匹配的规则决定路径是否被忽略
+func (d *ignoreDir) match(path string, isDir bool) bool {
+	for ; d != nil; d = d.parent {
+		rel, err := filepath.Rel(d.dir, path)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for i := len(d.patterns) - 1; i >= 0; i-- {
+			p := d.patterns[i]
+			if p.dirOnly && !isDir {
+				continue
+			}
+			if p.re.MatchString(rel) {
+				return !p.negate
+			}
+		}
+	}
+	return false
+}
+
+// loadIgnorePatterns 读取目录下的所有忽略文件，不存在或无法读取的文件被忽略
+func loadIgnorePatterns(dir string) []ignorePattern {
+	var patterns []ignorePattern
+	for _, name := range ignoreFileNames {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if p, ok := parseIgnorePattern(scanner.Text()); ok {
+				patterns = append(patterns, p)
+			}
+		}
+		f.Close()
+	}
+	return patterns
+}
+
+// parseIgnorePattern 按 gitignore 语法解析一行规则
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	var p ignorePattern
+
+	// 去掉未转义的行尾空格
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return p, false
+	}
+
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return p, false
+	}
+
+	// 包含 / 的规则相对于忽略文件所在目录，否则匹配任意层级的名字
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if !anchored && !strings.HasPrefix(line, "**/") {
+		line = "**/" + line
+	}
+
+	re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+	if err != nil {
+		return p, false
+	}
+	p.re = re
+	return p, true
+}
+
+// globToRegexp 将 gitignore 风格的通配符转换为正则表达式
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			// 开头或中间的 **/ 匹配零个或多个目录
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**") && i+2 == len(glob):
+			// 结尾的 ** 匹配其下所有内容
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return sb.String()
+}
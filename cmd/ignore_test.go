@@ -0,0 +1,153 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseIgnorePattern(t *testing.T) {
+	tests := []struct {
+		line   string
+		path   string // 相对于忽略文件所在目录
+		isDir  bool
+		match  bool
+		negate bool
+	}{
+		// 不含 / 的规则匹配任意层级的名字
+		{line: "*.log", path: "a.log", match: true},
+		{line: "*.log", path: "x/y/a.log", match: true},
+		{line: "*.log", path: "a.log.txt"},
+		{line: "debug?.txt", path: "x/debug1.txt", match: true},
+		{line: "debug?.txt", path: "x/debug10.txt"},
+		{line: "[ab].go", path: "a.go", match: true},
+		{line: "[!ab].go", path: "a.go"},
+		{line: "[!ab].go", path: "c.go", match: true},
+
+		// 含 / 的规则相对于忽略文件所在目录
+		{line: "/build", path: "build", isDir: true, match: true},
+		{line: "/build", path: "x/build", isDir: true},
+		{line: "doc/*.md", path: "doc/a.md", match: true},
+		{line: "doc/*.md", path: "x/doc/a.md"},
+		{line: "doc/*.md", path: "doc/x/a.md"},
+
+		// **
+		{line: "**/cache", path: "cache", isDir: true, match: true},
+		{line: "**/cache", path: "a/b/cache", isDir: true, match: true},
+		{line: "a/**/b", path: "a/b", match: true},
+		{line: "a/**/b", path: "a/x/y/b", match: true},
+		{line: "a/**/b", path: "x/a/b"},
+		{line: "out/**", path: "out/x/y", match: true},
+		{line: "out/**", path: "out"},
+
+		// 结尾的 / 只匹配目录
+		{line: "tmp/", path: "tmp", isDir: true, match: true},
+		{line: "tmp/", path: "x/tmp", isDir: true, match: true},
+		{line: "tmp/", path: "tmp"},
+
+		// ! 重新包含，\! 和 \# 是字面的文件名
+		{line: "!keep.log", path: "keep.log", match: true, negate: true},
+		{line: `\!important`, path: "!important", match: true},
+		{line: `\#notes`, path: "#notes", match: true},
+		{line: `a\*b`, path: "a*b", match: true},
+		{line: `a\*b`, path: "axb"},
+
+		// 行尾未转义的空格被去掉
+		{line: "name.txt  ", path: "name.txt", match: true},
+		{line: `space\ `, path: "space ", match: true},
+	}
+	for _, tt := range tests {
+		p, ok := parseIgnorePattern(tt.line)
+		if !ok {
+			t.Errorf("parseIgnorePattern(%q) 解析失败", tt.line)
+			continue
+		}
+		if p.negate != tt.negate {
+			t.Errorf("parseIgnorePattern(%q).negate = %v，期望 %v", tt.line, p.negate, tt.negate)
+		}
+		got := p.re.MatchString(tt.path) && (!p.dirOnly || tt.isDir)
+		if got != tt.match {
+			t.Errorf("%q 匹配 %q (目录 %v) = %v，期望 %v，正则 %s", tt.line, tt.path, tt.isDir, got, tt.match, p.re)
+		}
+	}
+}
+
+func TestParseIgnorePatternSkipped(t *testing.T) {
+	for _, line := range []string{"", "   ", "# comment", "!", "/"} {
+		if _, ok := parseIgnorePattern(line); ok {
+			t.Errorf("parseIgnorePattern(%q) 应被跳过", line)
+		}
+	}
+}
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob string
+		want string
+	}{
+		{"*.go", `[^/]*\.go`},
+		{"a?c", `a[^/]c`},
+		{"**/x", `(?:.*/)?x`},
+		{"a/**/b", `a/(?:.*/)?b`},
+		{"out/**", `out/.*`},
+		{"[!a-c]", `[^a-c]`},
+		{"[abc", `\[abc`},
+		{`\[x\]`, `\[x\]`},
+	}
+	for _, tt := range tests {
+		if got := globToRegexp(tt.glob); got != tt.want {
+			t.Errorf("globToRegexp(%q) = %s，期望 %s", tt.glob, got, tt.want)
+		}
+	}
+}
+
+func TestIgnoreTree(t *testing.T) {
+	root := t.TempDir()
+	write := func(rel, content string) {
+		t.Helper()
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".gitignore", "*.log\n/build\ntmp/\n")
+	// 子目录的规则优先于父目录，.fdignore 优先于同一目录的 .gitignore
+	write("sub/.gitignore", "!keep.log\n")
+	write("sub/deep/.ignore", "keep.log\n")
+	write("other/.gitignore", "*.txt\n")
+	write("other/.fdignore", "!readme.txt\n")
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"a.log", false, true},
+		{"a.txt", false, false},
+		{"x/y/a.log", false, true},
+		{"build", true, true},
+		{"sub/build", true, false},
+		{"tmp", true, true},
+		{"sub/tmp", true, true},
+		{"tmp", false, false},
+		{"sub/keep.log", false, false},
+		{"sub/other.log", false, true},
+		{"sub/x/keep.log", false, false},
+		{"sub/deep/keep.log", false, true},
+		{"other/a.txt", false, true},
+		{"other/readme.txt", false, false},
+		{"a/readme.txt", false, false},
+	}
+	tree := newIgnoreTree(root)
+	for _, tt := range tests {
+		if got := tree.Ignored(filepath.Join(root, tt.path), tt.isDir); got != tt.ignored {
+			t.Errorf("Ignored(%q, %v) = %v，期望 %v", tt.path, tt.isDir, got, tt.ignored)
+		}
+	}
+	if tree.Ignored(root, true) {
+		t.Errorf("根目录本身不应被忽略")
+	}
+}
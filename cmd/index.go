@@ -0,0 +1,582 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/junegunn/fzf/src/util"
+)
+
+// fileIndex 是某个搜索目录的常驻内存文件索引，
+// 启动时完整遍历一次，之后由 fsnotify 事件增量更新
+type fileIndex struct {
+	root   string // 绝对路径
+	policy WalkPolicy
+
+	mu         sync.RWMutex
+	walker     *walker   // 最近一次完整遍历使用的遍历器，缓存了忽略规则
+	generation uint64    // 每次文件列表变化时递增
+	stats      walkStats // 最近一次完整遍历的统计
+	builtAt    time.Time
+	updatedAt  time.Time
+
+	// 按路径排序的候选及其文件信息，文件事件按位置插入、删除和修改。
+	// 返回给调用方的切片可能仍在使用，之后的第一次修改先复制（写时复制）
+	files       []string
+	meta        []fileMeta
+	filesShared bool
+	metaShared  bool
+
+	// 候选列表的 fzf 字符表示，由进程内匹配器按需建立，之后随候选列表更新
+	chars       []util.Chars
+	charsShared bool
+
+	watcher      *fsnotify.Watcher
+	rebuildTimer *time.Timer // 忽略规则变化后等待执行的重建
+
+	ready     chan struct{} // 首次构建完成后关闭
+	readyErr  error         // 首次构建的错误
+	buildTime time.Duration // 最近一次完整遍历的耗时
+	lastErr   string        // 最近一次构建或监听的错误
+	lastErrAt time.Time
+	watching  bool   // 监听是否仍在运行
+	stale     bool   // 内容来自快照，尚未完成校对
+	savedGen  uint64 // 最近一次保存快照时的 generation
+}
+
+// IndexStatus 描述搜索所用索引的新鲜度
+type IndexStatus struct {
+	Name       string    `json:"name,omitempty"` // 根目录名
+	Root       string    `json:"root"`
+	Generation uint64    `json:"generation"`
+	Files      int       `json:"files"`
+	MaxFiles   int       `json:"maxFiles,omitempty"`
+	BuiltAt    time.Time `json:"builtAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	AgeMs      int64     `json:"ageMs"` // 距离最近一次更新的毫秒数
+
+	Building    bool      `json:"building,omitempty"`  // 首次构建尚未完成
+	BuildMs     int64     `json:"buildMs"`             // 最近一次完整遍历的耗时
+	LastError   string    `json:"lastError,omitempty"` // 最近一次构建或监听的错误
+	LastErrorAt time.Time `json:"lastErrorAt"`
+	Stale       bool      `json:"stale,omitempty"` // 内容来自快照，后台校对完成前可能过期
+	Watching    bool      `json:"watching"`        // 文件监听是否在运行
+	WatchedDirs int       `json:"watchedDirs"`     // 正在监听的目录数
+}
+
+var (
+	indexesMu sync.Mutex
+	indexes   = map[string]*fileIndex{} // 以绝对路径为键
+)
+
+// getIndex 返回目录对应的索引，不存在时构建并开始监听。
+// 构建期间不持有全局锁，其他目录的索引不受影响
+func getIndex(dir string) (*fileIndex, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	indexesMu.Lock()
+	idx, ok := indexes[absDir]
+	if !ok {
+		idx = newFileIndex(absDir)
+		indexes[absDir] = idx
+		go idx.start()
+	}
+	indexesMu.Unlock()
+
+	<-idx.ready
+	if idx.readyErr != nil {
+		// 构建失败的索引不保留，下次访问时重试
+		dropIndex(absDir, idx)
+		return nil, idx.readyErr
+	}
+	return idx, nil
+}
+
+// lookupIndex 返回已存在的索引（可能仍在构建中），不会触发构建
+func lookupIndex(dir string) *fileIndex {
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+	return indexes[dir]
+}
+
+// dropIndex 停止并移除目录的索引。idx 不为 nil 时只移除该索引实例
+func dropIndex(dir string, idx *fileIndex) {
+	indexesMu.Lock()
+	cur, ok := indexes[dir]
+	if ok && (idx == nil || cur == idx) {
+		delete(indexes, dir)
+	}
+	indexesMu.Unlock()
+
+	if ok && (idx == nil || cur == idx) {
+		cur.Close()
+	}
+}
+
+func newFileIndex(root string) *fileIndex {
+	return &fileIndex{
+		root:   root,
+		policy: defaultPolicy,
+		ready:  make(chan struct{}),
+	}
+}
+
+// start 启动 fsnotify 监听并完整遍历目录构建索引。
+// 有可用的快照或文件目录中有记录时先用它们提供搜索，再在后台完整遍历校对
+func (idx *fileIndex) start() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		idx.readyErr = fmt.Errorf("创建文件监听失败: %v", err)
+		close(idx.ready)
+		return
+	}
+	idx.mu.Lock()
+	idx.watcher = watcher
+	idx.watching = true
+	idx.mu.Unlock()
+
+	if idx.loadSnapshot() || idx.loadCatalog() {
+		close(idx.ready)
+		if err := idx.rebuild(); err != nil {
+			// 校对失败时继续使用快照中的内容
+			log.Printf("校对快照失败 %s: %v", idx.root, err)
+		}
+		go idx.watch()
+		return
+	}
+
+	err = idx.rebuild()
+	idx.readyErr = err
+	close(idx.ready)
+	if err == nil {
+		go idx.watch()
+	}
+}
+
+// rebuild 重新完整遍历根目录并替换索引内容
+func (idx *fileIndex) rebuild() error {
+	start := time.Now()
+	w, err := newWalker(idx.root, idx.policy, idx.watchDir)
+	if err != nil {
+		idx.setError(err)
+		return err
+	}
+	res, err := w.walk(context.Background(), idx.root)
+	if err != nil {
+		idx.setError(err)
+		return err
+	}
+
+	res.sort()
+
+	idx.mu.Lock()
+	idx.walker = w
+	idx.setFiles(res.Files, res.Meta)
+	idx.stats = res.walkStats
+	idx.touch()
+	idx.builtAt = idx.updatedAt
+	idx.buildTime = time.Since(start)
+	idx.stale = false
+	idx.mu.Unlock()
+
+	log.Printf("索引构建完成: %s, %d 个文件, 耗时 %v", idx.root, len(res.Files), idx.buildTime)
+	if catalog != nil && isRootPath(idx.root) {
+		catalog.Sync(idx.root, res.Files, res.Meta)
+	}
+	if res.Truncated {
+		log.Printf("警告: %s 超过最大文件数 %d，索引不完整", idx.root, maxFiles)
+	}
+	if res.Skipped > 0 {
+		log.Printf("警告: %s 有 %d 个条目无法读取，已跳过", idx.root, res.Skipped)
+	}
+	return nil
+}
+
+// scheduleRebuild 在忽略规则变化后延迟重建索引，合并短时间内的多次变化
+func (idx *fileIndex) scheduleRebuild() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.rebuildTimer != nil {
+		return
+	}
+	idx.rebuildTimer = time.AfterFunc(time.Second, func() {
+		idx.mu.Lock()
+		idx.rebuildTimer = nil
+		closed := !idx.watching
+		idx.mu.Unlock()
+
+		if closed {
+			return
+		}
+		if err := idx.rebuild(); err != nil {
+			log.Printf("重建索引失败 %s: %v", idx.root, err)
+		}
+	})
+}
+
+// setError 记录最近一次错误，供管理页面查看
+func (idx *fileIndex) setError(err error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.lastErr = err.Error()
+	idx.lastErrAt = time.Now()
+}
+
+// watchDir 为目录添加监听，失败时（例如超出 inotify 上限）只记录日志
+func (idx *fileIndex) watchDir(path string) {
+	idx.mu.RLock()
+	closed := !idx.watching
+	idx.mu.RUnlock()
+	if closed {
+		return
+	}
+
+	if err := idx.watcher.Add(path); err != nil {
+		log.Printf("无法监听目录 %s: %v", path, err)
+		idx.setError(fmt.Errorf("无法监听目录 %s: %v", path, err))
+	}
+}
+
+// Candidates 返回当前按路径排序的候选文件列表、对应的文件信息及 generation，返回的切片不可修改
+func (idx *fileIndex) Candidates() ([]string, []fileMeta, uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.filesShared, idx.metaShared = true, true
+	n := len(idx.files)
+	return idx.files[:n:n], idx.meta[:n:n], idx.generation
+}
+
+// Generation 返回当前的 generation，用于判断候选列表是否变化
+func (idx *fileIndex) Generation() uint64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.generation
+}
+
+// CandidateChars 返回 generation 为 gen 的候选列表的 fzf 字符表示，顺序与 Candidates 相同。
+// 第一次调用时建立，之后随候选列表更新；候选列表已经变化时返回 nil
+func (idx *fileIndex) CandidateChars(gen uint64) []util.Chars {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.generation != gen {
+		return nil
+	}
+	if idx.chars == nil {
+		idx.chars = make([]util.Chars, len(idx.files))
+		for i, file := range idx.files {
+			idx.chars[i] = util.ToChars([]byte(file))
+		}
+	}
+	idx.charsShared = true
+	n := len(idx.chars)
+	return idx.chars[:n:n]
+}
+
+// setFiles 替换全部候选，files 已按路径排序。调用方需持有写锁
+func (idx *fileIndex) setFiles(files []string, meta []fileMeta) {
+	// 完整遍历的结果可能还交给了文件目录，同样按共用处理
+	idx.files, idx.meta = files, meta
+	idx.filesShared, idx.metaShared = true, true
+	idx.chars, idx.charsShared = nil, false
+}
+
+// upsertFiles 加入新的候选并更新已有候选的文件信息，返回候选列表是否变化。
+// 少量新候选逐个插入，较多时与原列表归并。调用方需持有写锁
+func (idx *fileIndex) upsertFiles(files []string, meta []fileMeta) bool {
+	sort.Sort(filesByPath{files, meta})
+
+	var added []int
+	for i, file := range files {
+		j, ok := slices.BinarySearch(idx.files, file)
+		if !ok {
+			added = append(added, i)
+			continue
+		}
+		if idx.meta[j] != meta[i] {
+			if idx.metaShared {
+				idx.meta = slices.Clone(idx.meta)
+				idx.metaShared = false
+			}
+			idx.meta[j] = meta[i]
+		}
+	}
+	if len(added) == 0 {
+		return false
+	}
+
+	if len(added) > 16 || idx.filesShared || idx.metaShared || idx.charsShared {
+		idx.mergeFiles(files, meta, added)
+		return true
+	}
+	for _, i := range added {
+		j, _ := slices.BinarySearch(idx.files, files[i])
+		idx.files = slices.Insert(idx.files, j, files[i])
+		idx.meta = slices.Insert(idx.meta, j, meta[i])
+		if idx.chars != nil {
+			idx.chars = slices.Insert(idx.chars, j, util.ToChars([]byte(files[i])))
+		}
+	}
+	return true
+}
+
+// mergeFiles 将 files 中下标为 added 的新候选与原列表归并到新分配的切片中，files 已排序
+func (idx *fileIndex) mergeFiles(files []string, meta []fileMeta, added []int) {
+	n := len(idx.files) + len(added)
+	mergedFiles := make([]string, 0, n)
+	mergedMeta := make([]fileMeta, 0, n)
+	var mergedChars []util.Chars
+	if idx.chars != nil {
+		mergedChars = make([]util.Chars, 0, n)
+	}
+
+	j := 0
+	for _, i := range added {
+		for j < len(idx.files) && idx.files[j] < files[i] {
+			mergedFiles = append(mergedFiles, idx.files[j])
+			mergedMeta = append(mergedMeta, idx.meta[j])
+			if idx.chars != nil {
+				mergedChars = append(mergedChars, idx.chars[j])
+			}
+			j++
+		}
+		mergedFiles = append(mergedFiles, files[i])
+		mergedMeta = append(mergedMeta, meta[i])
+		if idx.chars != nil {
+			mergedChars = append(mergedChars, util.ToChars([]byte(files[i])))
+		}
+	}
+	mergedFiles = append(mergedFiles, idx.files[j:]...)
+	mergedMeta = append(mergedMeta, idx.meta[j:]...)
+	if idx.chars != nil {
+		mergedChars = append(mergedChars, idx.chars[j:]...)
+	}
+
+	idx.files, idx.meta, idx.chars = mergedFiles, mergedMeta, mergedChars
+	idx.filesShared, idx.metaShared, idx.charsShared = false, false, false
+}
+
+// deleteFiles 删除 [lo, hi) 范围内的候选。调用方需持有写锁
+func (idx *fileIndex) deleteFiles(lo, hi int) {
+	idx.files = deleteRange(idx.files, lo, hi, idx.filesShared)
+	idx.meta = deleteRange(idx.meta, lo, hi, idx.metaShared)
+	if idx.chars != nil {
+		idx.chars = deleteRange(idx.chars, lo, hi, idx.charsShared)
+	}
+	idx.filesShared, idx.metaShared, idx.charsShared = false, false, false
+}
+
+// deleteRange 删除切片中 [lo, hi) 的元素。shared 为 true 时切片可能仍在使用，复制后再删除
+func deleteRange[T any](s []T, lo, hi int, shared bool) []T {
+	if !shared {
+		return slices.Delete(s, lo, hi)
+	}
+	c := make([]T, 0, len(s)-(hi-lo))
+	c = append(c, s[:lo]...)
+	return append(c, s[hi:]...)
+}
+
+// Status 返回索引当前状态
+func (idx *fileIndex) Status() *IndexStatus {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	status := &IndexStatus{
+		Root:        idx.root,
+		Generation:  idx.generation,
+		Files:       len(idx.files),
+		MaxFiles:    maxFiles,
+		BuiltAt:     idx.builtAt,
+		UpdatedAt:   idx.updatedAt,
+		AgeMs:       time.Since(idx.updatedAt).Milliseconds(),
+		BuildMs:     idx.buildTime.Milliseconds(),
+		LastError:   idx.lastErr,
+		LastErrorAt: idx.lastErrAt,
+		Stale:       idx.stale,
+		Watching:    idx.watching,
+	}
+	select {
+	case <-idx.ready:
+	default:
+		status.Building = true
+	}
+	if idx.watching {
+		status.WatchedDirs = len(idx.watcher.WatchList())
+	}
+	return status
+}
+
+// Stats 返回最近一次完整遍历的统计
+func (idx *fileIndex) Stats() walkStats {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.stats
+}
+
+// Close 停止监听和等待中的重建
+func (idx *fileIndex) Close() error {
+	<-idx.ready
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.rebuildTimer != nil {
+		idx.rebuildTimer.Stop()
+		idx.rebuildTimer = nil
+	}
+	if idx.watcher == nil {
+		return nil
+	}
+	idx.watching = false
+	return idx.watcher.Close()
+}
+
+// watch 处理 fsnotify 事件，直到监听被关闭
+func (idx *fileIndex) watch() {
+	defer func() {
+		idx.mu.Lock()
+		idx.watching = false
+		idx.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case event, ok := <-idx.watcher.Events:
+			if !ok {
+				return
+			}
+			idx.handleEvent(event)
+		case err, ok := <-idx.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("文件监听错误 %s: %v", idx.root, err)
+			idx.setError(fmt.Errorf("文件监听错误: %v", err))
+		}
+	}
+}
+
+func (idx *fileIndex) handleEvent(event fsnotify.Event) {
+	rel, err := filepath.Rel(idx.root, event.Name)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return
+	}
+
+	// 忽略规则变化会影响整棵子树，直接重建
+	if !idx.policy.NoIgnore && isIgnoreFile(filepath.Base(event.Name)) {
+		idx.scheduleRebuild()
+		return
+	}
+
+	switch {
+	case event.Has(fsnotify.Create):
+		idx.addPath(event.Name, rel)
+	case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+		// 重命名后的新路径会以 Create 事件到达
+		idx.watcher.Remove(event.Name)
+		idx.removePath(rel)
+	case event.Has(fsnotify.Write):
+		// 写入前可能错过了 Create 事件，确保文件在索引中
+		idx.addPath(event.Name, rel)
+	}
+}
+
+// addPath 将新建的文件或目录（连同其子树）加入索引
+func (idx *fileIndex) addPath(path, rel string) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+
+	idx.mu.RLock()
+	w := idx.walker
+	idx.mu.RUnlock()
+
+	// 跟随符号链接时按链接目标的类型处理
+	isDir := info.IsDir()
+	if info.Mode()&os.ModeSymlink != 0 && w.policy.Follow {
+		if target, err := os.Stat(path); err == nil {
+			isDir = target.IsDir()
+		}
+	}
+
+	var (
+		added []string
+		meta  []fileMeta
+	)
+	if isDir {
+		// 目录本身及其子树由遍历器按策略过滤
+		res, err := w.walk(context.Background(), path)
+		if err != nil {
+			log.Printf("遍历新目录失败 %s: %v", path, err)
+			return
+		}
+		for _, warning := range res.Warnings {
+			log.Printf("警告: %s", warning)
+		}
+		added, meta = res.Files, res.Meta
+	} else if w.accept(path, rel, false) {
+		added = append(added, rel)
+		meta = append(meta, linkMeta(path, info, nil))
+	}
+
+	idx.mu.Lock()
+	if idx.upsertFiles(added, meta) {
+		idx.touch()
+	}
+	idx.mu.Unlock()
+
+	// 写入事件也会改变大小和修改时间，已存在的文件同样需要更新。
+	// 在释放锁之后写入，数据库缓慢时不影响搜索
+	if catalog != nil && isRootPath(idx.root) {
+		catalog.Upsert(idx.root, added, meta)
+	}
+}
+
+// removePath 从索引中移除文件，或目录下的所有文件
+func (idx *fileIndex) removePath(rel string) {
+	idx.mu.Lock()
+
+	// 目录下的文件在排序后是连续的，但不一定紧跟在目录之后（"a" < "a.txt" < "a/b"），
+	// 先删除后面的目录内容，目录本身的下标不受影响
+	prefix := rel + string(filepath.Separator)
+	changed := false
+	lo, _ := slices.BinarySearch(idx.files, prefix)
+	hi := lo
+	for hi < len(idx.files) && strings.HasPrefix(idx.files[hi], prefix) {
+		hi++
+	}
+	if hi > lo {
+		idx.deleteFiles(lo, hi)
+		changed = true
+	}
+	if i, ok := slices.BinarySearch(idx.files, rel); ok {
+		idx.deleteFiles(i, i+1)
+		changed = true
+	}
+	if changed {
+		idx.touch()
+	}
+	idx.mu.Unlock()
+
+	if changed && catalog != nil && isRootPath(idx.root) {
+		catalog.Remove(idx.root, rel)
+	}
+}
+
+// touch 记录一次索引变化，调用方需持有写锁
+func (idx *fileIndex) touch() {
+	idx.generation++
+	idx.updatedAt = time.Now()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// newTestIndex 按 policy 完整遍历 root 建立索引，不监听文件变化
+func newTestIndex(t *testing.T, root string, policy WalkPolicy) *fileIndex {
+	t.Helper()
+	idx := newFileIndex(root)
+	idx.policy = policy
+	if err := idx.rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+// indexView 是某一时刻 Candidates 和 CandidateChars 返回的切片，以及当时内容的副本
+type indexView struct {
+	files      []string
+	meta       []fileMeta
+	chars      []util.Chars
+	generation uint64
+
+	savedFiles []string
+	savedMeta  []fileMeta
+	savedChars []string
+}
+
+// viewIndex 取得索引当前的候选，同时复制一份内容用于之后比较
+func viewIndex(t *testing.T, idx *fileIndex) indexView {
+	t.Helper()
+	files, meta, gen := idx.Candidates()
+	chars := idx.CandidateChars(gen)
+	if len(chars) != len(files) {
+		t.Fatalf("字符表示有 %d 项，候选有 %d 项", len(chars), len(files))
+	}
+	return indexView{
+		files:      files,
+		meta:       meta,
+		chars:      chars,
+		generation: gen,
+		savedFiles: slices.Clone(files),
+		savedMeta:  slices.Clone(meta),
+		savedChars: charsStrings(chars),
+	}
+}
+
+// charsStrings 返回字符表示对应的字符串
+func charsStrings(chars []util.Chars) []string {
+	s := make([]string, len(chars))
+	for i, c := range chars {
+		s[i] = c.ToString()
+	}
+	return s
+}
+
+// checkIndex 检查索引的候选为 want，文件信息和字符表示与候选一一对应
+func checkIndex(t *testing.T, idx *fileIndex, want []string) indexView {
+	t.Helper()
+	v := viewIndex(t, idx)
+	if !slices.Equal(v.files, want) {
+		t.Fatalf("候选 = %q，期望 %q", v.files, want)
+	}
+	if !slices.Equal(v.savedChars, want) {
+		t.Errorf("字符表示 = %q，期望与候选相同", v.savedChars)
+	}
+	for i, rel := range v.files {
+		info, err := os.Stat(filepath.Join(idx.root, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := v.meta[i]; m.Mode.IsDir() != info.IsDir() || !info.IsDir() && m.Size != info.Size() {
+			t.Errorf("%s 的文件信息 = %+v，与文件不符", rel, m)
+		}
+	}
+	return v
+}
+
+// unchanged 检查之前返回的切片内容没有被之后的更新改动
+func (v indexView) unchanged(t *testing.T, step string) {
+	t.Helper()
+	if !slices.Equal(v.files, v.savedFiles) {
+		t.Errorf("%s 改动了之前返回的候选: %q，原为 %q", step, v.files, v.savedFiles)
+	}
+	if !slices.Equal(v.meta, v.savedMeta) {
+		t.Errorf("%s 改动了之前返回的文件信息", step)
+	}
+	if got := charsStrings(v.chars); !slices.Equal(got, v.savedChars) {
+		t.Errorf("%s 改动了之前返回的字符表示: %q，原为 %q", step, got, v.savedChars)
+	}
+}
+
+func TestIndexUpdates(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, "a.txt", "dir.txt", "dir/b.txt", "dir/sub/c.txt")
+	// 同时包含目录，删除目录时要连同子树一起移除
+	idx := newTestIndex(t, root, WalkPolicy{Entries: entriesAll})
+	path := func(rel string) string { return filepath.Join(root, rel) }
+	add := func(rel string) { idx.addPath(path(rel), rel) }
+
+	var many []string
+	for i := range 20 {
+		many = append(many, fmt.Sprintf("many/f%02d.txt", i))
+	}
+
+	// 每一步模拟监听事件：新建为 Create，删除为 Remove，重命名为旧路径的 Rename 加新路径的 Create
+	steps := []struct {
+		name   string
+		change func()
+		want   []string
+	}{
+		{"新建文件", func() {
+			writeFiles(t, root, "new.txt", "dir/d.txt")
+			add("new.txt")
+			add("dir/d.txt")
+		}, []string{"a.txt", "dir", "dir.txt", "dir/b.txt", "dir/d.txt", "dir/sub", "dir/sub/c.txt", "new.txt"}},
+		{"重命名文件", func() {
+			if err := os.Rename(path("a.txt"), path("z.txt")); err != nil {
+				t.Fatal(err)
+			}
+			idx.removePath("a.txt")
+			add("z.txt")
+		}, []string{"dir", "dir.txt", "dir/b.txt", "dir/d.txt", "dir/sub", "dir/sub/c.txt", "new.txt", "z.txt"}},
+		{"删除文件", func() {
+			if err := os.Remove(path("new.txt")); err != nil {
+				t.Fatal(err)
+			}
+			idx.removePath("new.txt")
+		}, []string{"dir", "dir.txt", "dir/b.txt", "dir/d.txt", "dir/sub", "dir/sub/c.txt", "z.txt"}},
+		{"写入文件", func() {
+			if err := os.WriteFile(path("dir.txt"), []byte("longer content"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			add("dir.txt")
+		}, []string{"dir", "dir.txt", "dir/b.txt", "dir/d.txt", "dir/sub", "dir/sub/c.txt", "z.txt"}},
+		{"新建目录", func() {
+			writeFiles(t, root, "tree/w.txt", "tree/x/y.txt")
+			add("tree")
+		}, []string{"dir", "dir.txt", "dir/b.txt", "dir/d.txt", "dir/sub", "dir/sub/c.txt", "tree", "tree/w.txt", "tree/x", "tree/x/y.txt", "z.txt"}},
+		{"重命名目录", func() {
+			if err := os.Rename(path("dir"), path("moved")); err != nil {
+				t.Fatal(err)
+			}
+			idx.removePath("dir")
+			add("moved")
+		}, []string{"dir.txt", "moved", "moved/b.txt", "moved/d.txt", "moved/sub", "moved/sub/c.txt", "tree", "tree/w.txt", "tree/x", "tree/x/y.txt", "z.txt"}},
+		{"删除目录", func() {
+			if err := os.RemoveAll(path("tree")); err != nil {
+				t.Fatal(err)
+			}
+			idx.removePath("tree")
+		}, []string{"dir.txt", "moved", "moved/b.txt", "moved/d.txt", "moved/sub", "moved/sub/c.txt", "z.txt"}},
+		{"新建大量文件", func() {
+			writeFiles(t, root, many...)
+			add("many")
+		}, append(append([]string{"dir.txt", "many"}, many...), "moved", "moved/b.txt", "moved/d.txt", "moved/sub", "moved/sub/c.txt", "z.txt")},
+		{"删除子目录", func() {
+			if err := os.RemoveAll(path("moved/sub")); err != nil {
+				t.Fatal(err)
+			}
+			idx.removePath(filepath.Join("moved", "sub"))
+		}, append(append([]string{"dir.txt", "many"}, many...), "moved", "moved/b.txt", "moved/d.txt", "z.txt")},
+	}
+
+	prev := viewIndex(t, idx)
+	for _, step := range steps {
+		step.change()
+		v := checkIndex(t, idx, step.want)
+		if v.generation == prev.generation && !slices.Equal(prev.savedFiles, step.want) {
+			t.Errorf("%s 后 generation 没有变化", step.name)
+		}
+		prev.unchanged(t, step.name)
+		prev = v
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileMeta 是遍历时记录的候选文件信息，搜索结果直接使用，不再逐个读取文件。
+// 符号链接记录目标的信息，目标不存在时记录链接本身
+type fileMeta struct {
+	Size    int64 // 目录为 0
+	ModTime time.Time
+	Mode    fs.FileMode
+	Symlink bool   // 条目本身是符号链接
+	Owner   string // 属主，不支持的平台上为空
+	Group   string // 属组
+	MIME    string // 没有扩展名的普通文件读取内容判断的类型
+}
+
+// linkMeta 由 Lstat 的结果生成文件信息。符号链接使用 target，为 nil 时读取链接目标
+func linkMeta(path string, info, target fs.FileInfo) fileMeta {
+	symlink := info.Mode()&fs.ModeSymlink != 0
+	if symlink {
+		if target == nil {
+			target, _ = os.Stat(path)
+		}
+		if target != nil {
+			info = target
+		}
+	}
+
+	meta := fileMeta{
+		ModTime: info.ModTime(),
+		Mode:    info.Mode(),
+		Symlink: symlink,
+	}
+	meta.Owner, meta.Group = ownerOf(info)
+	if !info.IsDir() {
+		meta.Size = info.Size()
+	}
+	// 没有扩展名的文件无法按扩展名判断类型，只读取普通文件，打开管道等特殊文件可能阻塞
+	if info.Mode().IsRegular() && filepath.Ext(path) == "" {
+		meta.MIME = sniffMIME(path)
+	}
+	return meta
+}
+
+// mime 返回扩展名（不含点）对应的 MIME 类型，没有扩展名时使用遍历时读取内容判断的类型
+func (m fileMeta) mime(ext string) string {
+	if ext == "" {
+		return m.MIME
+	}
+	return mimeByExt(ext)
+}
+
+// statMeta 读取路径的文件信息
+func statMeta(path string) (fileMeta, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fileMeta{}, err
+	}
+	return linkMeta(path, info, nil), nil
+}
+
+// filesByPath 将候选连同文件信息按路径排序
+type filesByPath struct {
+	files []string
+	meta  []fileMeta
+}
+
+func (f filesByPath) Len() int           { return len(f.files) }
+func (f filesByPath) Less(i, j int) bool { return f.files[i] < f.files[j] }
+func (f filesByPath) Swap(i, j int) {
+	f.files[i], f.files[j] = f.files[j], f.files[i]
+	f.meta[i], f.meta[j] = f.meta[j], f.meta[i]
+}
+
+// sort 按路径排序候选，文件信息随之调整
+func (r *walkResult) sort() {
+	sort.Sort(filesByPath{r.Files, r.Meta})
+}
+
+// find 返回路径在候选中的下标，不存在时返回 -1
+func (set *candidateSet) find(path string) int {
+	i, ok := slices.BinarySearch(set.Files, path)
+	if !ok {
+		return -1
+	}
+	return i
+}
+
+// result 生成第 i 个候选的结果。有文件信息时直接使用，
+// 否则读取文件，文件已不存在时返回 false，结果中只有路径和得分
+func (set *candidateSet) result(i int, score int, positions []int) (SearchResult, bool) {
+	path := set.Files[i]
+	result := SearchResult{
+		Root:      set.Name,
+		Path:      path,
+		Filename:  filepath.Base(path),
+		Score:     score,
+		Positions: positions,
+		set:       set,
+		index:     i,
+	}
+
+	if set.Meta != nil {
+		result.setMeta(set.Meta[i])
+		return result, true
+	}
+	meta, err := statMeta(filepath.Join(set.Dir, path))
+	if err != nil {
+		return result, false
+	}
+	result.setMeta(meta)
+	return result, true
+}
+
+// setMeta 用文件信息填充结果，MIME 类型按扩展名判断，没有扩展名时使用读取内容判断的类型
+func (r *SearchResult) setMeta(meta fileMeta) {
+	r.Size = meta.Size
+	r.ModTime = meta.ModTime
+	r.Mode = meta.Mode.String()
+	r.IsDir = meta.Mode.IsDir()
+	r.Symlink = meta.Symlink
+	r.Owner = meta.Owner
+	r.Group = meta.Group
+	r.Ext, r.MIME = "", ""
+	if !r.IsDir {
+		r.Ext = strings.ToLower(strings.TrimPrefix(filepath.Ext(r.Filename), "."))
+		r.MIME = meta.mime(r.Ext)
+	}
+}
+
+// 标准库和系统 MIME 表中可能没有的常见扩展名
+var extraMIMETypes = map[string]string{
+	".md":   "text/markdown",
+	".csv":  "text/csv",
+	".txt":  "text/plain",
+	".log":  "text/plain",
+	".go":   "text/x-go",
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":  "application/vnd.ms-excel",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".zip":  "application/zip",
+	".gz":   "application/gzip",
+	".tar":  "application/x-tar",
+	".mp3":  "audio/mpeg",
+	".mp4":  "video/mp4",
+}
+
+func init() {
+	for ext, typ := range extraMIMETypes {
+		if mime.TypeByExtension(ext) == "" {
+			mime.AddExtensionType(ext, typ)
+		}
+	}
+}
+
+// mimeByExt 返回扩展名（不含点）对应的 MIME 类型，不含参数，未知时返回空
+func mimeByExt(ext string) string {
+	if ext == "" {
+		return ""
+	}
+	typ, _, _ := strings.Cut(mime.TypeByExtension("."+ext), ";")
+	return strings.TrimSpace(typ)
+}
+
+// sniffMIME 读取文件开头判断 MIME 类型，不含参数
+func sniffMIME(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	if n == 0 {
+		return ""
+	}
+	typ, _, _ := strings.Cut(http.DetectContentType(buf[:n]), ";")
+	return typ
+}
+
+// fill 在 verify 为 true 时重新读取本页结果的文件信息，已不存在的文件标记为 missing，
+// 扩展名无法判断 MIME 类型的文件读取内容判断，记录在 ContentType 中。MIME 与遍历时的判断相同
+func (resp *SearchResponse) fill(verify bool) {
+	if !verify {
+		return
+	}
+	for i := range resp.Results {
+		r := &resp.Results[i]
+		path := filepath.Join(r.set.Dir, r.Path)
+		meta, err := statMeta(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			r.Missing = true
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		r.setMeta(meta)
+		r.Missing = false
+		// 只读取普通文件，打开管道等特殊文件可能阻塞
+		if r.MIME == "" && meta.Mode.IsRegular() {
+			r.ContentType = sniffMIME(path)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFill(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "notes.txt")
+	if err := os.WriteFile(filepath.Join(dir, "page.unknownext"), []byte("<html><body>x</body></html>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	set := testSet("a", dir, "gone.unknownext", "notes.txt", "page.unknownext")
+	page := func() *SearchResponse {
+		resp := &SearchResponse{}
+		for i := range set.Files {
+			r, _ := set.result(i, 0, nil)
+			resp.Results = append(resp.Results, r)
+		}
+		return resp
+	}
+
+	// 不校验时不读取文件，MIME 只按扩展名判断
+	resp := page()
+	resp.fill(false)
+	for _, r := range resp.Results {
+		if r.Missing || r.ContentType != "" || r.Size != 0 {
+			t.Errorf("不校验时 %s 的结果被修改: %+v", r.Path, r)
+		}
+	}
+	if got := resp.Results[1].MIME; got != "text/plain" {
+		t.Errorf("notes.txt 的 MIME = %q，期望 text/plain", got)
+	}
+
+	resp = page()
+	resp.fill(true)
+	gone, notes, unknown := resp.Results[0], resp.Results[1], resp.Results[2]
+	if !gone.Missing {
+		t.Errorf("已不存在的文件应标记为 missing")
+	}
+	if notes.Missing || notes.Size != int64(len("notes.txt")) || notes.MIME != "text/plain" || notes.ContentType != "" {
+		t.Errorf("notes.txt 的结果不正确: %+v", notes)
+	}
+	// 按内容判断的类型不改变按扩展名判断的 MIME
+	if unknown.MIME != "" || unknown.ContentType != "text/html" {
+		t.Errorf("page.unknownext 的 MIME = %q，ContentType = %q，期望空和 text/html", unknown.MIME, unknown.ContentType)
+	}
+}
+
+func TestSniffExtensionless(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, "README", "notes.txt", "bin/tool.txt")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	if err := os.WriteFile(filepath.Join(root, "logo"), png, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 遍历时读取没有扩展名的普通文件判断类型，有扩展名的文件和目录不读取
+	res, err := walkFiles(context.Background(), root, WalkPolicy{Entries: entriesAll})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.sort()
+	set := testSet("a", root)
+	set.Files, set.Meta = res.Files, res.Meta
+	want := map[string]string{"README": "text/plain", "logo": "image/png", "bin": "", "notes.txt": ""}
+	for i, file := range set.Files {
+		typ, ok := want[file]
+		if !ok {
+			continue
+		}
+		if got := set.Meta[i].MIME; got != typ {
+			t.Errorf("%s 读取内容判断的类型 = %q，期望 %q", file, got, typ)
+		}
+		r, _ := set.result(i, 0, nil)
+		if file == "notes.txt" {
+			typ = "text/plain"
+		}
+		if r.MIME != typ {
+			t.Errorf("%s 结果的 MIME = %q，期望 %q", file, r.MIME, typ)
+		}
+	}
+
+	// mime: 条件和按类型排序使用同样的类型
+	f := &MetaFilter{MIME: []string{"image"}}
+	for i, file := range set.Files {
+		if got := f.match(file, set.Meta[i]); got != (file == "logo") {
+			t.Errorf("mime:image 对 %s 的判断 = %v", file, got)
+		}
+	}
+}
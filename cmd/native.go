@@ -0,0 +1,284 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// nativeSearcher 在进程内直接调用 fzf 的匹配算法，不经过 fzf.Run。
+// 候选按 CPU 核数分片并行匹配，各分片排好序后用堆合并；
+// 查询语法、得分和 tiebreak 规则与 fzf 相同。匹配根目录索引时复用索引缓存的字符表示
+type nativeSearcher struct{}
+
+// rankKey 是排序用的键，规则与 fzf 相同：从后向前比较 points，都相同时按候选的顺序
+type rankKey struct {
+	points [4]uint16 // fzf 的比较值，points[3] 由得分决定，之前依次是各 tiebreak，越小越靠前
+	order  int       // 在全部候选中的顺序
+}
+
+// before 判断 a 是否应排在 b 之前
+func (a rankKey) before(b rankKey) bool {
+	for i := 3; i >= 0; i-- {
+		if a.points[i] != b.points[i] {
+			return a.points[i] < b.points[i]
+		}
+	}
+	return a.order < b.order
+}
+
+// rankedItem 是一个匹配的候选
+type rankedItem struct {
+	set   int // 所在候选集的下标
+	index int // 在候选集中的下标
+	score int
+	key   rankKey
+}
+
+// tiebreakCriteria 返回生效的 tiebreak 依据。未设置时与 fzf 相同，由评分方案决定：
+// path 为 pathname,length，history 只按输入顺序，其他为 length。index 总是隐含在最后
+func tiebreakCriteria(opts MatchOptions) []string {
+	tiebreak := opts.Tiebreak
+	if tiebreak == "" {
+		switch opts.scheme() {
+		case "path":
+			tiebreak = "pathname,length"
+		case "history":
+			tiebreak = "index"
+		default:
+			tiebreak = "length"
+		}
+	}
+	criteria := strings.Split(tiebreak, ",")
+	if i := slices.Index(criteria, "index"); i >= 0 {
+		criteria = criteria[:i]
+	}
+	return criteria
+}
+
+// tiebreakPoints 按 fzf 的 buildResult 计算排序用的比较值，span 是匹配区间。
+// 没有匹配区间时，除 length 外的依据取最大值
+func tiebreakPoints(criteria []string, chars *util.Chars, score int, span matchSpan) [4]uint16 {
+	var points [4]uint16
+	points[3] = math.MaxUint16 - util.AsUint16(score)
+	numChars := chars.Length()
+	for i, c := range criteria {
+		val := uint16(math.MaxUint16)
+		switch c {
+		case "length":
+			val = chars.TrimLength()
+		case "chunk":
+			// 包含匹配的、以空白分隔的片段的长度
+			if span.valid {
+				b, e := span.minBegin, span.maxEnd
+				for b >= 1 && !unicode.IsSpace(chars.Get(b-1)) {
+					b--
+				}
+				for e < numChars && !unicode.IsSpace(chars.Get(e)) {
+					e++
+				}
+				val = util.AsUint16(e - b)
+			}
+		case "pathname":
+			// 匹配从文件名开始时最优，匹配在文件名之前的排在最后。
+			// 与 fzf 相同，最后一个分隔符的下标按字节计
+			if span.valid {
+				delim := -1
+				s := chars.ToString()
+				for j := len(s) - 1; j >= 0; j-- {
+					if s[j] == '/' || s[j] == '\\' {
+						delim = j
+						break
+					}
+				}
+				if delim <= span.minBegin {
+					val = util.AsUint16(span.minBegin - delim)
+				}
+			}
+		case "begin", "end":
+			if span.valid {
+				// 开头的空白不计
+				white := 0
+				for j := 0; j < numChars; j++ {
+					white = j
+					if j == span.minBegin || !unicode.IsSpace(chars.Get(j)) {
+						break
+					}
+				}
+				if c == "begin" {
+					val = util.AsUint16(span.minEnd - white)
+				} else {
+					val = util.AsUint16(math.MaxUint16 - math.MaxUint16*(span.maxEnd-white)/(int(chars.TrimLength())+1))
+				}
+			}
+		}
+		points[2-i] = val
+	}
+	return points
+}
+
+// candidateChars 返回候选集的字符表示：候选集自带的、根目录索引缓存的，都没有时返回 nil
+func candidateChars(set *candidateSet) []util.Chars {
+	if set.chars != nil && len(set.chars) == len(set.Files) {
+		return set.chars
+	}
+	// 子目录的候选是根目录索引的一部分，路径也不同，不能使用索引的字符表示
+	if set.Index == nil || set.Index.Root != set.Dir {
+		return nil
+	}
+	idx := lookupIndex(set.Index.Root)
+	if idx == nil {
+		return nil
+	}
+	if chars := idx.CandidateChars(set.Index.Generation); len(chars) == len(set.Files) {
+		return chars
+	}
+	return nil
+}
+
+// matchAll 并行匹配全部候选，返回按相关度排序的全部匹配。
+// 每个分片排好自己的匹配，最后用堆合并各分片。调用方需共享 fzfGlobals
+func matchAll(ctx context.Context, query string, sets []*candidateSet, opts MatchOptions) ([]rankedItem, error) {
+	base := newQueryMatcher(query, opts)
+
+	// 候选集按顺序首尾相接，offsets[i] 是第 i 个候选集第一个候选的全局顺序
+	offsets := make([]int, len(sets)+1)
+	chars := make([][]util.Chars, len(sets))
+	for i, set := range sets {
+		offsets[i+1] = offsets[i] + len(set.Files)
+		chars[i] = candidateChars(set)
+	}
+	total := offsets[len(sets)]
+
+	workers := max(1, min(runtime.GOMAXPROCS(0), total/1024))
+	parts := make([][]rankedItem, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m := base.clone()
+			lo, hi := total*w/workers, total*(w+1)/workers
+			s := 0
+			var items []rankedItem
+			for order := lo; order < hi; order++ {
+				if (order-lo)%1024 == 0 && ctx.Err() != nil {
+					return
+				}
+				for order >= offsets[s+1] {
+					s++
+				}
+				i := order - offsets[s]
+
+				var c util.Chars
+				if chars[s] != nil {
+					c = chars[s][i]
+				} else {
+					c = util.ToChars([]byte(sets[s].Files[i]))
+				}
+				ok, score, points := m.rank(&c)
+				if !ok {
+					continue
+				}
+				items = append(items, rankedItem{set: s, index: i, score: score, key: rankKey{points, order}})
+			}
+			slices.SortFunc(items, func(a, b rankedItem) int {
+				if a.key.before(b.key) {
+					return -1
+				}
+				return 1
+			})
+			parts[w] = items
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return mergeParts(parts), nil
+}
+
+// partHead 是合并时一个分片当前的第一个匹配
+type partHead struct {
+	part []rankedItem
+	pos  int
+}
+
+type headHeap []partHead
+
+func (h headHeap) Len() int { return len(h) }
+func (h headHeap) Less(i, j int) bool {
+	return h[i].part[h[i].pos].key.before(h[j].part[h[j].pos].key)
+}
+func (h headHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *headHeap) Push(x any)   { *h = append(*h, x.(partHead)) }
+func (h *headHeap) Pop() any {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// mergeParts 合并各自排好序的分片
+func mergeParts(parts [][]rankedItem) []rankedItem {
+	var h headHeap
+	n := 0
+	for _, part := range parts {
+		if len(part) > 0 {
+			h = append(h, partHead{part: part})
+			n += len(part)
+		}
+	}
+	heap.Init(&h)
+
+	merged := make([]rankedItem, 0, n)
+	for len(merged) < n {
+		head := &h[0]
+		merged = append(merged, head.part[head.pos])
+		if head.pos++; head.pos == len(head.part) {
+			heap.Pop(&h)
+		} else {
+			heap.Fix(&h, 0)
+		}
+	}
+	return merged
+}
+
+// resultsOf 为匹配生成结果并计算匹配位置，ctx 取消后停止。调用方需共享 fzfGlobals
+func resultsOf(ctx context.Context, query string, sets []*candidateSet, opts MatchOptions, items []rankedItem, emit func(SearchResult)) {
+	m := newQueryMatcher(query, opts)
+	for n, item := range items {
+		if n%1024 == 0 && ctx.Err() != nil {
+			return
+		}
+		set := sets[item.set]
+		chars := util.ToChars([]byte(set.Files[item.index]))
+		_, _, _, positions := m.match(&chars, true)
+		if result, ok := set.result(item.index, item.score, positions); ok {
+			result.rank = item.key
+			emit(result)
+		}
+	}
+}
+
+// Search 按相关度顺序输出全部匹配，sorted 为 false 时也是如此
+func (nativeSearcher) Search(ctx context.Context, query string, sets []*candidateSet, opts MatchOptions, sorted bool, emit func(SearchResult)) error {
+	defer fzfGlobals.share()()
+	items, err := matchAll(ctx, query, sets, opts)
+	if err != nil {
+		return err
+	}
+	resultsOf(ctx, query, sets, opts, items, emit)
+	return ctx.Err()
+}
+
+func (nativeSearcher) Positions(query string, opts MatchOptions, paths []string) ([][]int, error) {
+	return fzfPositions(query, opts, paths), nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// fzfOrder 返回 fzf 排序后输出的候选，不经过结果的转换和重新排序
+func fzfOrder(t *testing.T, query string, set *candidateSet, opts MatchOptions) []string {
+	t.Helper()
+	args, err := fzfArgs(query, 1, opts, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	err = runFzfAPI(context.Background(), args, []*candidateSet{set}, func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lines
+}
+
+func TestNativeMatchesFzf(t *testing.T) {
+	// 评分方案是服务端的设置，只能比较服务端的方案
+	edge := testSet("edge", "/edge",
+		"finance/draft184.txt", "docs/draft_draft5.md", "  draft/lead.md", `win\dir\draft.txt`,
+		"a draft/b c.go", "日本/draft.md", "draft", "x/yd/raft.txt", "drafts/notes/draft")
+	queries := append(slices.Clone(benchQueries), "!test main", "^src go$", "json | yaml cfg", "'draft", "!zzz")
+	for _, set := range []*candidateSet{benchSet(20000), edge} {
+		for _, tiebreak := range []string{"", "length", "end,length", "chunk,begin", "begin", "pathname,end", "index"} {
+			for _, query := range append(queries, "draft", "/draft", "dra ft", "'draft' | raft") {
+				opts := defaultMatch.merge(MatchOptions{Engine: engineNative, Tiebreak: tiebreak})
+				t.Run(fmt.Sprintf("%s/%s/%s", set.Name, tiebreak, query), func(t *testing.T) {
+					want := fzfOrder(t, query, set, opts)
+					results, err := collectResults(context.Background(), searchers[engineNative], query, []*candidateSet{set}, opts)
+					if err != nil {
+						t.Fatal(err)
+					}
+					var got []string
+					for _, r := range results {
+						got = append(got, r.Path)
+					}
+					if len(got) != len(want) {
+						t.Fatalf("native 有 %d 个结果，fzf 有 %d 个", len(got), len(want))
+					}
+					for i := range got {
+						if got[i] != want[i] {
+							t.Fatalf("第 %d 个结果: native 为 %s，fzf 为 %s", i, got[i], want[i])
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestTiebreakPoints(t *testing.T) {
+	const maxPoint = math.MaxUint16
+	valid := func(begin, end int) matchSpan { return matchSpan{begin, end, end, true} }
+	tests := []struct {
+		text     string
+		criteria []string
+		score    int
+		span     matchSpan
+		want     [4]uint16
+	}{
+		// 得分在最后比较，超出范围时取最大值
+		{"main.go", nil, 100, valid(0, 4), [4]uint16{0, 0, 0, maxPoint - 100}},
+		{"main.go", nil, 70000, valid(0, 4), [4]uint16{0, 0, 0, 0}},
+		// length 不计首尾的空白，依据按顺序从 points[2] 向前排列
+		{" ab ", []string{"length"}, 0, valid(1, 2), [4]uint16{0, 0, 2, maxPoint}},
+		{"a/bc/main.go", []string{"pathname", "length"}, 0, valid(5, 9), [4]uint16{0, 12, 1, maxPoint}},
+		{"a/bc/main.go", []string{"pathname"}, 0, valid(2, 9), [4]uint16{0, 0, maxPoint, maxPoint}},
+		// 分隔符的下标按字节计
+		{"日本/x.go", []string{"pathname"}, 0, valid(3, 4), [4]uint16{0, 0, maxPoint, maxPoint}},
+		{"日本/x.go", []string{"pathname"}, 0, valid(7, 8), [4]uint16{0, 0, 1, maxPoint}},
+		{"ab cde fg", []string{"chunk"}, 0, valid(3, 5), [4]uint16{0, 0, 3, maxPoint}},
+		// begin 比较的是最靠前的区间终点，开头的空白不计
+		{"  main", []string{"begin", "end"}, 0, matchSpan{2, 6, 6, true}, [4]uint16{0, 13107, 4, maxPoint}},
+		{"x  main", []string{"begin"}, 0, matchSpan{3, 5, 7, true}, [4]uint16{0, 0, 5, maxPoint}},
+		// 没有匹配区间时只有 length 有效
+		{"ab", []string{"chunk", "pathname", "length"}, 0, matchSpan{}, [4]uint16{2, maxPoint, maxPoint, maxPoint}},
+	}
+	for _, tt := range tests {
+		chars := util.ToChars([]byte(tt.text))
+		if got := tiebreakPoints(tt.criteria, &chars, tt.score, tt.span); got != tt.want {
+			t.Errorf("tiebreakPoints(%q, %q, %d, %+v) = %v，期望 %v", tt.criteria, tt.text, tt.score, tt.span, got, tt.want)
+		}
+	}
+}
+
+func TestTiebreakCriteria(t *testing.T) {
+	tests := []struct {
+		opts             MatchOptions
+		want             []string
+		forward, withPos bool
+	}{
+		{MatchOptions{Scheme: "default"}, []string{"length"}, true, false},
+		{MatchOptions{Scheme: "path"}, []string{"pathname", "length"}, false, true},
+		{MatchOptions{Scheme: "history"}, []string{}, true, false},
+		{MatchOptions{Tiebreak: "begin,index"}, []string{"begin"}, true, false},
+		// 最靠前的 begin、end 或 pathname 决定方向
+		{MatchOptions{Tiebreak: "end,begin"}, []string{"end", "begin"}, false, false},
+		{MatchOptions{Tiebreak: "begin,pathname"}, []string{"begin", "pathname"}, true, true},
+		{MatchOptions{Tiebreak: "chunk,length"}, []string{"chunk", "length"}, true, true},
+	}
+	for _, tt := range tests {
+		got := tiebreakCriteria(tt.opts)
+		forward, withPos := tiebreakMode(got)
+		if !slices.Equal(got, tt.want) || forward != tt.forward || withPos != tt.withPos {
+			t.Errorf("%+v 的 tiebreak 为 %q %v %v，期望 %q %v %v", tt.opts, got, forward, withPos, tt.want, tt.forward, tt.withPos)
+		}
+	}
+}
+
+// BenchmarkSearch 比较 fzf API 与 native 引擎：都排序输出全部匹配，并为每个匹配生成带匹配位置的结果
+func BenchmarkSearch(b *testing.B) {
+	opts := defaultMatch
+	for _, n := range []int{100_000, 1_000_000} {
+		sets := []*candidateSet{benchSet(n)}
+		for _, engine := range []string{engineFzf, engineNative} {
+			for _, query := range benchQueries {
+				b.Run(fmt.Sprintf("%d/%s/%s", n, engine, query), func(b *testing.B) {
+					for range b.N {
+						if _, err := benchSearch(searchers[engine], query, sets, opts); err != nil {
+							b.Fatal(err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// MatchOptions 是允许客户端设置的 fzf 匹配选项，只会被转换为白名单中的 fzf 参数。
+// 未设置的字段依次使用根目录和服务端的默认值
+type MatchOptions struct {
+	Exact    *bool  `json:"exact,omitempty"`    // 精确匹配，对应 --exact
+	Case     string `json:"case,omitempty"`     // 大小写: smart、ignore 或 respect
+	Scheme   string `json:"scheme,omitempty"`   // 评分方案: default、path 或 history
+	Algo     string `json:"algo,omitempty"`     // 模糊匹配算法: v1 或 v2
+	Tiebreak string `json:"tiebreak,omitempty"` // 得分相同时的排序依据，逗号分隔
+	Literal  *bool  `json:"literal,omitempty"`  // 不对字母做归一化，对应 --literal
+	Engine   string `json:"engine,omitempty"`   // 搜索引擎: fzf、native、fzf-bin、substring 或 regex
+}
+
+// defaultMatch 是服务端默认的匹配选项，由配置文件设置。
+// 默认使用适合文件路径的 path 评分方案，评分方案只能在服务端设置
+var defaultMatch = MatchOptions{Scheme: "path"}
+
+var (
+	caseFlags = map[string]string{
+		"smart":   "--smart-case",
+		"ignore":  "-i",
+		"respect": "+i",
+	}
+	matchSchemes   = []string{"default", "path", "history"}
+	matchAlgos     = []string{"v1", "v2"}
+	tiebreakValues = []string{"length", "chunk", "pathname", "begin", "end", "index"}
+)
+
+// merge 返回用 o 中已设置的字段覆盖 base 后的选项
+func (base MatchOptions) merge(o MatchOptions) MatchOptions {
+	if o.Exact != nil {
+		base.Exact = o.Exact
+	}
+	if o.Case != "" {
+		base.Case = o.Case
+	}
+	if o.Scheme != "" {
+		base.Scheme = o.Scheme
+	}
+	if o.Algo != "" {
+		base.Algo = o.Algo
+	}
+	if o.Tiebreak != "" {
+		base.Tiebreak = o.Tiebreak
+	}
+	if o.Literal != nil {
+		base.Literal = o.Literal
+	}
+	if o.Engine != "" {
+		base.Engine = o.Engine
+	}
+	return base
+}
+
+// Equal 判断两组选项是否等价，未设置的 exact 和 literal 与 false 相同
+func (o MatchOptions) Equal(other MatchOptions) bool {
+	isSet := func(b *bool) bool { return b != nil && *b }
+	return isSet(o.Exact) == isSet(other.Exact) &&
+		o.Case == other.Case &&
+		o.Scheme == other.Scheme &&
+		o.Algo == other.Algo &&
+		o.Tiebreak == other.Tiebreak &&
+		isSet(o.Literal) == isSet(other.Literal) &&
+		o.engine() == other.engine()
+}
+
+// engine 返回搜索引擎名，未设置时为 fzf
+func (o MatchOptions) engine() string {
+	if o.Engine == "" {
+		return engineFzf
+	}
+	return o.Engine
+}
+
+// scheme 返回生效的评分方案：未设置时为服务端的方案，服务端也未设置时与 fzf 相同为 default
+func (o MatchOptions) scheme() string {
+	switch {
+	case o.Scheme != "":
+		return o.Scheme
+	case defaultMatch.Scheme != "":
+		return defaultMatch.Scheme
+	}
+	return "default"
+}
+
+// checkScheme 校验评分方案。评分方案是服务端的设置，由配置文件的 options 设置，
+// 进程内的 fzf 匹配算法只能使用一种方案；根目录和请求中可以省略，给出时必须与之相同
+func (o MatchOptions) checkScheme() error {
+	if o.Scheme != "" && o.Scheme != defaultMatch.Scheme {
+		return fmt.Errorf("scheme 只能在服务端设置，当前为 %s", defaultMatch.Scheme)
+	}
+	return nil
+}
+
+// args 校验选项并转换为 fzf 参数，搜索引擎不对应 fzf 参数，只做校验
+func (o MatchOptions) args() ([]string, error) {
+	if o.Engine != "" && searchers[o.Engine] == nil {
+		return nil, fmt.Errorf("无效的 engine: %q，可选 %s", o.Engine, strings.Join(searchEngines, "、"))
+	}
+	var args []string
+	if o.Exact != nil && *o.Exact {
+		args = append(args, "--exact")
+	}
+	if o.Case != "" {
+		flag, ok := caseFlags[o.Case]
+		if !ok {
+			return nil, fmt.Errorf("无效的 case: %q", o.Case)
+		}
+		args = append(args, flag)
+	}
+	// 总是给出评分方案，fzf 未设置方案时会按标准输入是否为终端选择
+	if !slices.Contains(matchSchemes, o.scheme()) {
+		return nil, fmt.Errorf("无效的 scheme: %q", o.Scheme)
+	}
+	args = append(args, "--scheme="+o.scheme())
+	if o.Algo != "" {
+		if !slices.Contains(matchAlgos, o.Algo) {
+			return nil, fmt.Errorf("无效的 algo: %q", o.Algo)
+		}
+		args = append(args, "--algo="+o.Algo)
+	}
+	if o.Tiebreak != "" {
+		// 与 fzf 相同，不能重复，index 只能放在最后，此外最多 3 项
+		criteria := strings.Split(o.Tiebreak, ",")
+		for i, c := range criteria {
+			if !slices.Contains(tiebreakValues, c) || slices.Contains(criteria[:i], c) ||
+				(c == "index" && i != len(criteria)-1) || (c != "index" && i >= 3) {
+				return nil, fmt.Errorf("无效的 tiebreak: %q", o.Tiebreak)
+			}
+		}
+		args = append(args, "--tiebreak="+o.Tiebreak)
+	}
+	if o.Literal != nil && *o.Literal {
+		args = append(args, "--literal")
+	}
+	return args, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatchOptionsScheme(t *testing.T) {
+	// 评分方案只能在服务端设置，根目录和请求只能省略或给出相同的值
+	targets := []searchTarget{{Name: "a", Dir: "/a"}}
+	for _, tt := range []struct {
+		root, req string
+		err       bool
+	}{
+		{"", "", false},
+		{"", defaultMatch.Scheme, false},
+		{defaultMatch.Scheme, "", false},
+		{"", "history", true},
+	} {
+		targets[0].Options.Scheme = tt.root
+		req := SearchRequest{Options: MatchOptions{Scheme: tt.req}}
+		opts, err := req.matchOptions(targets)
+		if (err != nil) != tt.err {
+			t.Errorf("根目录 %q、请求 %q: 错误 = %v", tt.root, tt.req, err)
+		}
+		if err == nil && opts.Scheme != defaultMatch.Scheme {
+			t.Errorf("根目录 %q、请求 %q: scheme = %q，期望 %q", tt.root, tt.req, opts.Scheme, defaultMatch.Scheme)
+		}
+	}
+
+	_, err := newRoot(RootConfig{Name: "a", Path: t.TempDir(), Options: MatchOptions{Scheme: "default"}})
+	if err == nil || !strings.Contains(err.Error(), "scheme") {
+		t.Errorf("根目录使用其他评分方案时的错误 = %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// ownerOf 在没有 uid 和 gid 的平台上不提供属主信息
+func ownerOf(info fs.FileInfo) (owner, group string) {
+	return "", ""
+}
@@ -0,0 +1,52 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+	"os/user"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// ownerKey 标识一个用户或组
+type ownerKey struct {
+	id    uint32
+	group bool
+}
+
+var (
+	ownerNamesMu sync.Mutex
+	ownerNames   = map[ownerKey]string{} // 已解析的用户名和组名
+)
+
+// ownerOf 返回文件属主和属组的名字
+func ownerOf(info fs.FileInfo) (owner, group string) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", ""
+	}
+	return ownerName(ownerKey{id: st.Uid}), ownerName(ownerKey{id: st.Gid, group: true})
+}
+
+// ownerName 解析用户名或组名并缓存，无法解析时使用数字 ID
+func ownerName(key ownerKey) string {
+	ownerNamesMu.Lock()
+	defer ownerNamesMu.Unlock()
+	if name, ok := ownerNames[key]; ok {
+		return name
+	}
+
+	id := strconv.FormatUint(uint64(key.id), 10)
+	name := id
+	if key.group {
+		if g, err := user.LookupGroupId(id); err == nil {
+			name = g.Name
+		}
+	} else if u, err := user.LookupId(id); err == nil {
+		name = u.Username
+	}
+	ownerNames[key] = name
+	return name
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPageSize = 100  // 未指定 limit 时每页的结果数
+	maxPageSize     = 1000 // 每页最多的结果数
+
+	resultSnapshotTTL  = 10 * time.Minute // 结果集最后一次访问后保留的时间
+	maxSnapshotResults = 4 << 20          // 全部结果集合计最多保留的结果数，超出时淘汰最久未访问的
+)
+
+// resultHit 是结果集中的一个结果：所在候选集、在候选集中的下标和得分
+type resultHit struct {
+	set   int32
+	index int32
+	score int32
+}
+
+// resultSnapshot 是一次搜索排序后的全部结果，翻页时从中读取。
+// 只保存结果在候选集中的位置和得分，翻页时才为这一页生成结果、计算匹配位置
+type resultSnapshot struct {
+	id       string
+	resp     SearchResponse  // 不含结果的响应
+	sets     []*candidateSet // 结果所在的候选集
+	hits     []resultHit
+	searcher Searcher
+	query    string
+	opts     MatchOptions
+	accessed time.Time
+}
+
+// newResultSnapshot 用排序后的全部结果生成结果集
+func newResultSnapshot(p *searchPlan, query string, results []SearchResult) *resultSnapshot {
+	snap := &resultSnapshot{
+		resp:     *p.response(len(results)),
+		hits:     make([]resultHit, len(results)),
+		searcher: p.searcher,
+		query:    query,
+		opts:     p.opts,
+	}
+
+	// 会话中缩小范围后的结果来自新的候选集，按结果所在的候选集记录
+	sets := map[*candidateSet]int32{}
+	for i, r := range results {
+		s, ok := sets[r.set]
+		if !ok {
+			s = int32(len(snap.sets))
+			sets[r.set] = s
+			snap.sets = append(snap.sets, r.set)
+		}
+		snap.hits[i] = resultHit{set: s, index: int32(r.index), score: int32(r.Score)}
+	}
+	return snap
+}
+
+// resultCache 保存最近的结果集
+type resultCache struct {
+	mu    sync.Mutex
+	snaps map[string]*resultSnapshot
+	total int // 全部结果集的结果数
+}
+
+var resultSnapshots = &resultCache{snaps: map[string]*resultSnapshot{}}
+
+// add 保存结果集并分配标识
+func (c *resultCache) add(snap *resultSnapshot) *resultSnapshot {
+	var b [8]byte
+	rand.Read(b[:])
+	snap.id = hex.EncodeToString(b[:])
+	snap.accessed = time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict(len(snap.hits))
+	c.snaps[snap.id] = snap
+	c.total += len(snap.hits)
+	return snap
+}
+
+// get 返回结果集，不存在或已过期时返回 nil
+func (c *resultCache) get(id string) *resultSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.evict(0)
+	snap := c.snaps[id]
+	if snap != nil {
+		snap.accessed = time.Now()
+	}
+	return snap
+}
+
+// evict 删除过期的结果集；n 大于 0 时再从最久未访问的开始删除，直到能再放入 n 个结果。
+// 单个结果集超过上限时删除其他全部结果集后仍然保存。调用方需持有锁
+func (c *resultCache) evict(n int) {
+	for id, snap := range c.snaps {
+		if time.Since(snap.accessed) > resultSnapshotTTL {
+			c.remove(id)
+		}
+	}
+	for n > 0 && len(c.snaps) > 0 && c.total+n > maxSnapshotResults {
+		var oldest *resultSnapshot
+		for _, snap := range c.snaps {
+			if oldest == nil || snap.accessed.Before(oldest.accessed) {
+				oldest = snap
+			}
+		}
+		c.remove(oldest.id)
+	}
+}
+
+// remove 删除结果集，调用方需持有锁
+func (c *resultCache) remove(id string) {
+	c.total -= len(c.snaps[id].hits)
+	delete(c.snaps, id)
+}
+
+// pageLimit 校验分页参数并返回实际的页大小，limit 为 0 时使用默认页大小
+func pageLimit(offset, limit int) (int, error) {
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if offset < 0 || limit < 0 || limit > maxPageSize {
+		return 0, fmt.Errorf("无效的分页参数: offset=%d limit=%d，limit 最大为 %d", offset, limit, maxPageSize)
+	}
+	return limit, nil
+}
+
+// page 返回结果集中的一页，为这一页的结果读取文件信息并计算匹配位置。
+// 文件信息不在索引中且文件已不存在时，结果标记为 missing
+func (s *resultSnapshot) page(offset, limit int) (*SearchResponse, error) {
+	limit, err := pageLimit(offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	start := min(offset, len(s.hits))
+	end := min(start+limit, len(s.hits))
+	hits := s.hits[start:end]
+	paths := make([]string, len(hits))
+	for i, h := range hits {
+		paths[i] = s.sets[h.set].Files[h.index]
+	}
+	positions, err := s.searcher.Positions(s.query, s.opts, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := s.resp
+	resp.SnapshotID = s.id
+	resp.Offset = offset
+	resp.Results = make([]SearchResult, len(hits))
+	for i, h := range hits {
+		r, ok := s.sets[h.set].result(int(h.index), int(h.score), positions[i])
+		r.Missing = !ok
+		resp.Results[i] = r
+	}
+	return &resp, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testSet 返回 dir 下的候选集，候选按路径排序，文件信息为空的普通文件
+func testSet(name, dir string, files ...string) *candidateSet {
+	set := &candidateSet{searchTarget: searchTarget{Name: name, Dir: dir}}
+	set.Files = slices.Sorted(slices.Values(files))
+	set.Meta = make([]fileMeta, len(files))
+	return set
+}
+
+func TestResultSnapshotPage(t *testing.T) {
+	opts := MatchOptions{Engine: engineSubstring}
+	p := &searchPlan{
+		sets: []*candidateSet{
+			testSet("a", "/a", "x/main.go", "main.md", "readme"),
+			testSet("b", "/b", "main", "src/main_test.go"),
+		},
+		opts:     opts,
+		searcher: searcherFor(opts),
+		start:    time.Now(),
+	}
+	results, err := collectResults(context.Background(), p.searcher, "main", p.sets, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("匹配了 %d 个结果，期望 4 个", len(results))
+	}
+
+	snap := newResultSnapshot(p, "main", results)
+	if snap.resp.Total != 4 || snap.resp.Results != nil {
+		t.Errorf("结果集的响应 Total = %d，结果 %d 个，期望 4 和不保存结果", snap.resp.Total, len(snap.resp.Results))
+	}
+	for _, tt := range []struct{ offset, limit int }{{0, 0}, {0, 3}, {1, 2}, {3, 10}, {10, 1}} {
+		resp, err := snap.page(tt.offset, tt.limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := results[min(tt.offset, len(results)):]
+		if tt.limit > 0 {
+			want = want[:min(tt.limit, len(want))]
+		}
+		if len(resp.Results) != len(want) {
+			t.Fatalf("page(%d, %d) 有 %d 个结果，期望 %d 个", tt.offset, tt.limit, len(resp.Results), len(want))
+		}
+		for i, r := range resp.Results {
+			w := want[i]
+			if r.Root != w.Root || r.Path != w.Path || r.Score != w.Score || !slices.Equal(r.Positions, w.Positions) || r.Missing {
+				t.Errorf("page(%d, %d)[%d] = %s/%s %d %v，期望 %s/%s %d %v",
+					tt.offset, tt.limit, i, r.Root, r.Path, r.Score, r.Positions, w.Root, w.Path, w.Score, w.Positions)
+			}
+		}
+	}
+
+	// 没有文件信息的候选在翻页时读取，文件已不存在的标记为 missing
+	set := testSet("c", t.TempDir(), "gone/main")
+	set.Meta = nil
+	r, _ := set.result(0, 1, nil)
+	p.sets = []*candidateSet{set}
+	resp, err := newResultSnapshot(p, "main", []SearchResult{r}).page(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 1 || !resp.Results[0].Missing || resp.Results[0].Path != "gone/main" {
+		t.Errorf("已不存在的文件的结果 = %+v", resp.Results)
+	}
+}
+
+func TestResultCacheEvict(t *testing.T) {
+	c := &resultCache{snaps: map[string]*resultSnapshot{}}
+	snapOf := func(n int) *resultSnapshot {
+		return &resultSnapshot{hits: make([]resultHit, n)}
+	}
+
+	// 按合计的结果数淘汰最久未访问的结果集
+	half := maxSnapshotResults / 2
+	first := c.add(snapOf(half))
+	second := c.add(snapOf(half - 1))
+	first.accessed = first.accessed.Add(-time.Second)
+	c.get(second.id)
+	third := c.add(snapOf(2))
+	if c.get(first.id) != nil {
+		t.Errorf("最久未访问的结果集应被淘汰")
+	}
+	if c.get(second.id) == nil || c.get(third.id) == nil {
+		t.Errorf("未超出上限的结果集被淘汰")
+	}
+	if c.total != half+1 {
+		t.Errorf("合计结果数 = %d，期望 %d", c.total, half+1)
+	}
+
+	// 超过上限的结果集删除其他全部结果集后仍然保存
+	big := c.add(snapOf(maxSnapshotResults + 1))
+	if len(c.snaps) != 1 || c.get(big.id) == nil {
+		t.Errorf("超过上限的结果集: 保留了 %d 个结果集", len(c.snaps))
+	}
+
+	// 过期的结果集被删除
+	big.accessed = time.Now().Add(-resultSnapshotTTL - time.Second)
+	if c.get(big.id) != nil || c.total != 0 {
+		t.Errorf("过期的结果集未被删除，合计结果数 %d", c.total)
+	}
+}
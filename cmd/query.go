@@ -0,0 +1,358 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 查询中可用的过滤运算符，形如 key:value。其他含冒号的词原样交给搜索引擎
+var queryOperators = map[string]func(f *MetaFilter, value string, now time.Time) error{
+	"ext":      parseExtOperator,
+	"size":     parseSizeOperator,
+	"modified": parseModifiedOperator,
+	"mtime":    parseModifiedOperator,
+	"type":     parseTypeOperator,
+	"mime":     parseMIMEOperator,
+	"in":       parseInOperator,
+}
+
+// parseQuery 从查询中拆出过滤运算符，如 "report ext:xlsx size:>1M modified:<7d in:finance/"，
+// 返回其余部分和运算符对应的过滤条件。其余部分按 fzf 的扩展搜索语法匹配，
+// 没有运算符时与原查询完全相同。相对时间以 now 为基准
+func parseQuery(query string, now time.Time) (string, MetaFilter, error) {
+	var (
+		filter MetaFilter
+		rest   []string
+		found  bool
+	)
+	for _, token := range queryTokens(query) {
+		key, value, ok := strings.Cut(token, ":")
+		parse := queryOperators[key]
+		if !ok || parse == nil {
+			rest = append(rest, token)
+			continue
+		}
+		found = true
+		value = strings.ReplaceAll(value, `\ `, " ")
+		if value == "" {
+			return "", MetaFilter{}, fmt.Errorf("%s: 后缺少值", key)
+		}
+		if err := parse(&filter, value, now); err != nil {
+			return "", MetaFilter{}, fmt.Errorf("%q: %v", token, err)
+		}
+	}
+	if !found {
+		return query, filter, nil
+	}
+	return strings.Join(rest, " "), filter, nil
+}
+
+// queryTokens 按未转义的空格拆分查询，与 fzf 扩展搜索语法相同，"\ " 表示词中的空格
+func queryTokens(query string) []string {
+	var tokens []string
+	start := -1
+	for i := 0; i < len(query); i++ {
+		if query[i] == ' ' && (i == 0 || query[i-1] != '\\') {
+			if start >= 0 {
+				tokens = append(tokens, query[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, query[start:])
+	}
+	return tokens
+}
+
+// parseQuery 将请求查询中的运算符合并到过滤条件中，Query 只保留交给搜索引擎的部分。
+// 相对时间按分钟取整，同一分钟内重复的查询得到相同的条件，搜索会话可以复用候选
+func (req *SearchRequest) parseQuery() error {
+	query, filter, err := parseQuery(req.Query, time.Now().Truncate(time.Minute))
+	if err != nil {
+		return &searchError{http.StatusBadRequest, "无效的查询: " + err.Error()}
+	}
+	if err := filter.validate(); err != nil {
+		return &searchError{http.StatusBadRequest, "无效的查询: " + err.Error()}
+	}
+	if err := req.Filter.validate(); err != nil {
+		return &searchError{http.StatusBadRequest, "无效的过滤条件: " + err.Error()}
+	}
+	if err := req.Filter.merge(filter); err != nil {
+		return &searchError{http.StatusBadRequest, "无效的查询: 与过滤条件冲突: " + err.Error()}
+	}
+	req.Query = query
+	return nil
+}
+
+// merge 合并另一组条件，两组条件都要满足：大小和修改时间取更窄的范围，
+// Ext、MIME 和 In 两边都设置时取交集。类型不同或列表没有交集时返回错误，f 不变。
+// 合并列表时两组条件都应已校验
+func (f *MetaFilter) merge(o MetaFilter) error {
+	if o.Type != "" && f.Type != "" && o.Type != f.Type {
+		return fmt.Errorf("类型 %s 与 %s 冲突", o.Type, f.Type)
+	}
+	ext, err := intersect("扩展名", f.Ext, o.Ext, func(a, b string) bool { return a == b })
+	if err != nil {
+		return err
+	}
+	mime, err := intersect("MIME 类别", f.MIME, o.MIME, mimeWithin)
+	if err != nil {
+		return err
+	}
+	in, err := intersect("目录", f.In, o.In, func(a, b string) bool { return a == b || strings.HasPrefix(a, b+"/") })
+	if err != nil {
+		return err
+	}
+	f.Ext, f.MIME, f.In = ext, mime, in
+
+	if o.MinSize != nil && (f.MinSize == nil || *o.MinSize > *f.MinSize) {
+		f.MinSize = o.MinSize
+	}
+	if o.MaxSize != nil && (f.MaxSize == nil || *o.MaxSize < *f.MaxSize) {
+		f.MaxSize = o.MaxSize
+	}
+	if o.After != nil && (f.After == nil || o.After.After(*f.After)) {
+		f.After = o.After
+	}
+	if o.Before != nil && (f.Before == nil || o.Before.Before(*f.Before)) {
+		f.Before = o.Before
+	}
+	if o.Type != "" {
+		f.Type = o.Type
+	}
+	return nil
+}
+
+// intersect 返回两组“满足任意一个即可”的值的交集，within(a, b) 判断满足 a 的一定满足 b。
+// 只有一边有值时返回这一边，两边都有值但没有交集时返回错误
+func intersect(what string, a, b []string, within func(a, b string) bool) ([]string, error) {
+	if len(a) == 0 {
+		return b, nil
+	}
+	if len(b) == 0 {
+		return a, nil
+	}
+	var both []string
+	for _, x := range a {
+		for _, y := range b {
+			v := ""
+			switch {
+			case within(x, y):
+				v = x
+			case within(y, x):
+				v = y
+			default:
+				continue
+			}
+			if !slices.Contains(both, v) {
+				both = append(both, v)
+			}
+		}
+	}
+	if len(both) == 0 {
+		return nil, fmt.Errorf("%s %s 与 %s 没有交集", what, strings.Join(b, ","), strings.Join(a, ","))
+	}
+	return both, nil
+}
+
+// parseExtOperator 解析 ext:pdf 或 ext:pdf,docx
+func parseExtOperator(f *MetaFilter, value string, now time.Time) error {
+	for _, ext := range strings.Split(value, ",") {
+		if ext == "" {
+			return fmt.Errorf("扩展名不能为空")
+		}
+		f.Ext = append(f.Ext, ext)
+	}
+	return nil
+}
+
+// parseMIMEOperator 解析 mime:image 或 mime:document,application/zip
+func parseMIMEOperator(f *MetaFilter, value string, now time.Time) error {
+	for _, category := range strings.Split(value, ",") {
+		if category == "" {
+			return fmt.Errorf("MIME 类别不能为空")
+		}
+		f.MIME = append(f.MIME, category)
+	}
+	return nil
+}
+
+// parseTypeOperator 解析 type:file、type:dir 或 type:symlink
+func parseTypeOperator(f *MetaFilter, value string, now time.Time) error {
+	switch value {
+	case typeFile, typeDir, typeSymlink:
+	default:
+		return fmt.Errorf("类型应为 %s、%s 或 %s", typeFile, typeDir, typeSymlink)
+	}
+	if f.Type != "" && f.Type != value {
+		return fmt.Errorf("与 type:%s 冲突", f.Type)
+	}
+	f.Type = value
+	return nil
+}
+
+// parseInOperator 解析 in:finance/，只保留根目录下该目录中的候选
+func parseInOperator(f *MetaFilter, value string, now time.Time) error {
+	f.In = append(f.In, value)
+	return nil
+}
+
+// comparison 匹配运算符值开头的比较符号
+var comparison = regexp.MustCompile(`^(>=|<=|>|<|=)?(.*)$`)
+
+// parseSizeOperator 解析 size:>1M、size:<=10K、size:1M..10M 或 size:0（等于）。
+// 单位为 K、M、G、T（1024 进制），可以加 B 或 iB
+func parseSizeOperator(f *MetaFilter, value string, now time.Time) error {
+	var lo, hi int64
+	if a, b, ok := strings.Cut(value, ".."); ok {
+		var err error
+		if lo, err = parseSize(a); err != nil {
+			return err
+		}
+		if hi, err = parseSize(b); err != nil {
+			return err
+		}
+	} else {
+		m := comparison.FindStringSubmatch(value)
+		n, err := parseSize(m[2])
+		if err != nil {
+			return err
+		}
+		lo, hi = 0, math.MaxInt64
+		switch m[1] {
+		case ">":
+			lo = n + 1
+		case ">=":
+			lo = n
+		case "<":
+			if n == 0 {
+				return fmt.Errorf("没有小于 0 的大小")
+			}
+			hi = n - 1
+		case "<=":
+			hi = n
+		default:
+			lo, hi = n, n
+		}
+	}
+	var r MetaFilter
+	if lo > 0 {
+		r.MinSize = &lo
+	}
+	if hi < math.MaxInt64 {
+		r.MaxSize = &hi
+	}
+	return f.merge(r)
+}
+
+// sizeUnits 是大小的单位
+var sizeUnits = map[string]float64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30, "T": 1 << 40}
+
+// sizePattern 匹配 1.5M、10KB、2GiB 这样的大小
+var sizePattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)([KMGT]?)(?:I?B)?$`)
+
+// parseSize 解析带单位的大小
+func parseSize(s string) (int64, error) {
+	m := sizePattern.FindStringSubmatch(strings.ToUpper(s))
+	if m == nil {
+		return 0, fmt.Errorf("无效的大小 %q，应为 500、10K、1.5M 这样的值", s)
+	}
+	n, _ := strconv.ParseFloat(m[1], 64)
+	size := n * sizeUnits[m[2]]
+	if size >= math.MaxInt64 {
+		return 0, fmt.Errorf("大小 %q 过大", s)
+	}
+	return int64(size), nil
+}
+
+// ageUnits 是相对时间的单位
+var ageUnits = map[string]time.Duration{
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour,
+}
+
+// agePattern 匹配 30h、7d、2w、1y 这样的相对时间
+var agePattern = regexp.MustCompile(`^(\d+)([hdwy])$`)
+
+// parseModifiedOperator 解析修改时间。值为相对时间时比较的是距今的时长：
+// modified:<7d 表示 7 天内修改过，modified:>7d 表示 7 天前修改；
+// 值为日期时按时间先后比较：modified:>=2026-01-01、modified:<2026-01-01；
+// 不带比较符号的日期表示当天，相对时间等同于 <；日期也可以写成 2026-01-01..2026-01-31
+func parseModifiedOperator(f *MetaFilter, value string, now time.Time) error {
+	if a, b, ok := strings.Cut(value, ".."); ok {
+		from, err := parseDate(a)
+		if err != nil {
+			return err
+		}
+		to, err := parseDate(b)
+		if err != nil {
+			return err
+		}
+		to = to.AddDate(0, 0, 1)
+		return f.merge(MetaFilter{After: &from, Before: &to})
+	}
+
+	m := comparison.FindStringSubmatch(value)
+	op, operand := m[1], m[2]
+	if age := agePattern.FindStringSubmatch(operand); age != nil {
+		n, _ := strconv.Atoi(age[1])
+		t := now.Add(-time.Duration(n) * ageUnits[age[2]])
+		switch op {
+		case "", "<", "<=":
+			return f.merge(MetaFilter{After: &t})
+		case ">", ">=":
+			return f.merge(MetaFilter{Before: &t})
+		}
+		return fmt.Errorf("相对时间只能用 < 或 > 比较")
+	}
+
+	day, err := parseDate(operand)
+	if err != nil {
+		return err
+	}
+	next := day.AddDate(0, 0, 1)
+	switch op {
+	case "<":
+		return f.merge(MetaFilter{Before: &day})
+	case "<=":
+		return f.merge(MetaFilter{Before: &next})
+	case ">":
+		return f.merge(MetaFilter{After: &next})
+	case ">=":
+		return f.merge(MetaFilter{After: &day})
+	}
+	return f.merge(MetaFilter{After: &day, Before: &next})
+}
+
+// parseDate 解析本地时间的日期 2006-01-02
+func parseDate(s string) (time.Time, error) {
+	t, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无效的时间 %q，应为 7d、24h、2w、1y 或 2006-01-02", s)
+	}
+	return t, nil
+}
+
+// scopeOf 规范化 in: 的目录，返回以 / 分隔、不含首尾 / 的相对路径。开头的 / 表示根目录
+func scopeOf(dir string) (string, error) {
+	scope := path.Clean(strings.Trim(filepath.ToSlash(dir), "/"))
+	if scope == "." || scope == ".." || strings.HasPrefix(scope, "../") {
+		return "", fmt.Errorf("无效的目录 %q，应为根目录下的相对路径", dir)
+	}
+	return scope, nil
+}
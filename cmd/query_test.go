@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ptr 返回 v 的指针
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestParseQuery(t *testing.T) {
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.Local)
+	day := func(d int) *time.Time { return ptr(time.Date(2026, 3, d, 0, 0, 0, 0, time.Local)) }
+	tests := []struct {
+		query  string
+		rest   string
+		filter MetaFilter
+		err    string // 错误信息包含的内容，为空时不应出错
+	}{
+		// 没有运算符时查询不变，包括多余的空格
+		{query: "main  go$", rest: "main  go$"},
+		{query: "foo:bar", rest: "foo:bar"},
+		{query: "report ext:xlsx,csv in:finance/", rest: "report", filter: MetaFilter{Ext: []string{"xlsx", "csv"}, In: []string{"finance/"}}},
+		{query: "ext:md ext:txt", filter: MetaFilter{Ext: []string{"md", "txt"}}},
+		{query: `in:my\ docs main`, rest: "main", filter: MetaFilter{In: []string{"my docs"}}},
+		{query: `a\ b type:dir`, rest: `a\ b`, filter: MetaFilter{Type: typeDir}},
+		{query: "mime:image,document", filter: MetaFilter{MIME: []string{"image", "document"}}},
+		{query: "size:>1K", filter: MetaFilter{MinSize: ptr[int64](1025)}},
+		{query: "size:<=1.5M", filter: MetaFilter{MaxSize: ptr[int64](3 << 19)}},
+		{query: "size:10KB..2MiB", filter: MetaFilter{MinSize: ptr[int64](10 << 10), MaxSize: ptr[int64](2 << 20)}},
+		{query: "size:0", filter: MetaFilter{MaxSize: ptr[int64](0)}},
+		// 同一查询中的范围取更窄的
+		{query: "size:>1K size:>=1M size:<1G", filter: MetaFilter{MinSize: ptr[int64](1 << 20), MaxSize: ptr[int64](1<<30 - 1)}},
+		{query: "modified:<7d", filter: MetaFilter{After: ptr(now.AddDate(0, 0, -7))}},
+		{query: "mtime:>2w", filter: MetaFilter{Before: ptr(now.AddDate(0, 0, -14))}},
+		{query: "modified:2026-03-10", filter: MetaFilter{After: day(10), Before: day(11)}},
+		{query: "modified:>2026-03-10", filter: MetaFilter{After: day(11)}},
+		{query: "modified:<=2026-03-10", filter: MetaFilter{Before: day(11)}},
+		{query: "modified:2026-03-01..2026-03-10 modified:<7d", filter: MetaFilter{After: ptr(now.AddDate(0, 0, -7)), Before: day(11)}},
+
+		{query: "ext:", err: "缺少值"},
+		{query: "ext:md,", err: "扩展名不能为空"},
+		{query: "type:pipe", err: "类型应为"},
+		{query: "type:file type:dir", err: "冲突"},
+		{query: "size:<0", err: "没有小于 0"},
+		{query: "size:1X", err: "无效的大小"},
+		{query: "modified:=7d", err: "只能用 < 或 >"},
+		{query: "modified:yesterday", err: "无效的时间"},
+	}
+	for _, tt := range tests {
+		rest, filter, err := parseQuery(tt.query, now)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseQuery(%q) 的错误 = %v，期望包含 %q", tt.query, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseQuery(%q) 出错: %v", tt.query, err)
+			continue
+		}
+		if rest != tt.rest || filterJSON(filter) != filterJSON(tt.filter) {
+			t.Errorf("parseQuery(%q) = %q %s，期望 %q %s", tt.query, rest, filterJSON(filter), tt.rest, filterJSON(tt.filter))
+		}
+	}
+}
+
+// filterJSON 返回条件的 JSON，用于比较
+func filterJSON(f MetaFilter) string {
+	data, _ := json.Marshal(f)
+	return string(data)
+}
+
+func TestMetaFilterMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		f, o MetaFilter
+		want MetaFilter
+		err  string
+	}{
+		{
+			name: "只有一边设置列表",
+			f:    MetaFilter{Ext: []string{"md"}},
+			o:    MetaFilter{MIME: []string{"text"}, In: []string{"docs"}},
+			want: MetaFilter{Ext: []string{"md"}, MIME: []string{"text"}, In: []string{"docs"}},
+		},
+		{
+			name: "扩展名取交集",
+			f:    MetaFilter{Ext: []string{"pdf", "doc", "md"}},
+			o:    MetaFilter{Ext: []string{"md", "pdf", "txt"}},
+			want: MetaFilter{Ext: []string{"pdf", "md"}},
+		},
+		{
+			name: "MIME 取更具体的类别",
+			f:    MetaFilter{MIME: []string{"application", "image"}},
+			o:    MetaFilter{MIME: []string{"document", "image/png", "text"}},
+			want: MetaFilter{MIME: []string{"document", "image/png"}},
+		},
+		{
+			name: "MIME 的完整类型属于类别",
+			f:    MetaFilter{MIME: []string{"application/pdf", "application/zip"}},
+			o:    MetaFilter{MIME: []string{"document"}},
+			want: MetaFilter{MIME: []string{"application/pdf"}},
+		},
+		{
+			name: "目录取更深的",
+			f:    MetaFilter{In: []string{"docs", "src/cmd"}},
+			o:    MetaFilter{In: []string{"docs/api", "src", "srcx"}},
+			want: MetaFilter{In: []string{"docs/api", "src/cmd"}},
+		},
+		{
+			name: "范围取更窄的，类型以有值的为准",
+			f:    MetaFilter{MinSize: ptr[int64](10), MaxSize: ptr[int64](100), Type: typeFile},
+			o:    MetaFilter{MinSize: ptr[int64](50), MaxSize: ptr[int64](200)},
+			want: MetaFilter{MinSize: ptr[int64](50), MaxSize: ptr[int64](100), Type: typeFile},
+		},
+		{
+			name: "扩展名没有交集",
+			f:    MetaFilter{Ext: []string{"pdf"}},
+			o:    MetaFilter{Ext: []string{"doc", "docx"}},
+			err:  "扩展名 doc,docx 与 pdf 没有交集",
+		},
+		{
+			name: "MIME 没有交集",
+			f:    MetaFilter{MIME: []string{"document"}},
+			o:    MetaFilter{MIME: []string{"image", "archive"}},
+			err:  "没有交集",
+		},
+		{
+			name: "目录没有交集",
+			f:    MetaFilter{In: []string{"src"}},
+			o:    MetaFilter{In: []string{"srcx"}},
+			err:  "没有交集",
+		},
+		{
+			name: "类型冲突",
+			f:    MetaFilter{Type: typeFile, Ext: []string{"md"}},
+			o:    MetaFilter{Type: typeDir},
+			err:  "冲突",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := tt.f
+			err := f.merge(tt.o)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("错误 = %v，期望包含 %q", err, tt.err)
+				}
+				if filterJSON(f) != filterJSON(tt.f) {
+					t.Errorf("出错后条件变为 %s，期望不变", filterJSON(f))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if filterJSON(f) != filterJSON(tt.want) {
+				t.Errorf("合并结果 = %s，期望 %s", filterJSON(f), filterJSON(tt.want))
+			}
+		})
+	}
+}
+
+func TestSearchRequestParseQuery(t *testing.T) {
+	tests := []struct {
+		query  string
+		filter MetaFilter
+		want   MetaFilter
+		code   int
+	}{
+		// 请求的条件与查询中的运算符规范化后再取交集
+		{"ext:MD,.Txt main", MetaFilter{Ext: []string{".md", "go"}}, MetaFilter{Ext: []string{"md"}}, 0},
+		{"in:/docs/api/", MetaFilter{In: []string{"docs"}}, MetaFilter{In: []string{"docs/api"}}, 0},
+		{"mime:Image", MetaFilter{MIME: []string{"image/png"}}, MetaFilter{MIME: []string{"image/png"}}, 0},
+		{"ext:pdf", MetaFilter{Ext: []string{"doc"}}, MetaFilter{Ext: []string{"doc"}}, http.StatusBadRequest},
+		{"type:dir", MetaFilter{Type: typeFile}, MetaFilter{Type: typeFile}, http.StatusBadRequest},
+		{"in:../x", MetaFilter{}, MetaFilter{}, http.StatusBadRequest},
+		{"main", MetaFilter{Ext: []string{""}}, MetaFilter{Ext: []string{""}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := SearchRequest{Query: tt.query, Filter: tt.filter}
+		err := req.parseQuery()
+		if tt.code != 0 {
+			if e, ok := err.(*searchError); !ok || e.status != tt.code {
+				t.Errorf("parseQuery(%q) 的错误 = %v，期望状态码 %d", tt.query, err, tt.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseQuery(%q) 出错: %v", tt.query, err)
+			continue
+		}
+		if filterJSON(req.Filter) != filterJSON(tt.want) {
+			t.Errorf("parseQuery(%q) 的条件 = %s，期望 %s", tt.query, filterJSON(req.Filter), filterJSON(tt.want))
+		}
+	}
+}
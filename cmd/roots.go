@@ -0,0 +1,374 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var (
+	// errForbidden 表示请求的路径不在任何允许的根目录之下
+	errForbidden = errors.New("目录不在允许的搜索范围内")
+	// errPaused 表示根目录已被管理员暂停
+	errPaused = errors.New("根目录已暂停")
+)
+
+// Root 是一个命名的搜索根目录，创建后不再修改，状态变化时整体替换
+type Root struct {
+	Name    string       `json:"name"`
+	Path    string       `json:"path"`             // 已解析符号链接的绝对路径
+	Paused  bool         `json:"paused,omitempty"` // 暂停后停止监听且不参与搜索
+	Options MatchOptions `json:"options"`          // 该根目录默认的匹配选项，包括搜索引擎
+}
+
+// RootConfig 是命令行 -root 或配置文件中的根目录定义
+type RootConfig struct {
+	Name    string       `json:"name"`
+	Path    string       `json:"path"`
+	Options MatchOptions `json:"options"` // 该根目录默认的匹配选项
+}
+
+var (
+	rootFlags   stringList   // -root name=/path 参数
+	configRoots []RootConfig // 配置文件中的根目录，命令行给出 -root 时不使用
+	allowFlags  stringList   // -allow 参数给出的额外根目录
+	dirSet      bool         // 是否显式指定了 -d 或配置文件中的 dir
+
+	rootsMu      sync.RWMutex
+	roots        []*Root  // 按定义顺序排列，第一个为默认根目录
+	allowedRoots []string // -allow 给出的额外目录，已解析符号链接
+)
+
+// parseRootFlag 解析 name=/path 形式的根目录定义，省略名字时使用目录名
+func parseRootFlag(value string) RootConfig {
+	if name, path, ok := strings.Cut(value, "="); ok {
+		return RootConfig{Name: name, Path: path}
+	}
+	return RootConfig{Name: filepath.Base(filepath.Clean(value)), Path: value}
+}
+
+// rootDefs 返回启动时的根目录定义。未指定 -root 时使用 -d 给出的目录，
+// 同时指定时 -d 的目录作为第一个（默认）根目录
+func rootDefs() []RootConfig {
+	var defs []RootConfig
+	if len(rootFlags) == 0 && len(configRoots) == 0 || dirSet {
+		defs = append(defs, parseRootFlag(baseDir))
+	}
+	for _, value := range rootFlags {
+		defs = append(defs, parseRootFlag(value))
+	}
+	return append(defs, configRoots...)
+}
+
+// initRoots 解析根目录定义和额外允许的目录，每个都必须是已存在的目录
+func initRoots(defs []RootConfig, allow []string) error {
+	var list []*Root
+	for _, def := range defs {
+		root, err := newRoot(def)
+		if err != nil {
+			return err
+		}
+		for _, r := range list {
+			if r.Name == root.Name {
+				return fmt.Errorf("重复的根目录名: %s", root.Name)
+			}
+		}
+		list = append(list, root)
+	}
+	if len(list) == 0 {
+		return errors.New("没有配置任何根目录")
+	}
+
+	var allowed []string
+	for _, dir := range allow {
+		real, err := resolveDir(dir)
+		if err != nil {
+			return err
+		}
+		allowed = append(allowed, real)
+	}
+
+	rootsMu.Lock()
+	defer rootsMu.Unlock()
+	roots = list
+	allowedRoots = allowed
+	return nil
+}
+
+// newRoot 校验根目录定义
+func newRoot(def RootConfig) (*Root, error) {
+	if def.Name == "" || strings.ContainsAny(def.Name, "\t/\\") {
+		return nil, fmt.Errorf("无效的根目录名: %q", def.Name)
+	}
+	if _, err := def.Options.args(); err != nil {
+		return nil, fmt.Errorf("根目录 %s: %v", def.Name, err)
+	}
+	if err := def.Options.checkScheme(); err != nil {
+		return nil, fmt.Errorf("根目录 %s: %v", def.Name, err)
+	}
+	real, err := resolveDir(def.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &Root{Name: def.Name, Path: real, Options: def.Options}, nil
+}
+
+// listRoots 返回所有根目录
+func listRoots() []*Root {
+	rootsMu.RLock()
+	defer rootsMu.RUnlock()
+	return slices.Clone(roots)
+}
+
+// findRoot 按名字查找根目录
+func findRoot(name string) *Root {
+	rootsMu.RLock()
+	defer rootsMu.RUnlock()
+	for _, r := range roots {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// defaultRoot 返回第一个根目录
+func defaultRoot() *Root {
+	rootsMu.RLock()
+	defer rootsMu.RUnlock()
+	return roots[0]
+}
+
+// addRoot 在运行时添加根目录，索引在后台构建
+func addRoot(def RootConfig) (*Root, error) {
+	root, err := newRoot(def)
+	if err != nil {
+		return nil, err
+	}
+
+	rootsMu.Lock()
+	for _, r := range roots {
+		if r.Name == root.Name {
+			rootsMu.Unlock()
+			return nil, fmt.Errorf("重复的根目录名: %s", root.Name)
+		}
+	}
+	roots = append(roots, root)
+	rootsMu.Unlock()
+
+	go buildRootIndex(root)
+	return root, nil
+}
+
+// removeRoot 在运行时移除根目录并停止其索引，至少保留一个根目录
+func removeRoot(name string) error {
+	rootsMu.Lock()
+	i := slices.IndexFunc(roots, func(r *Root) bool { return r.Name == name })
+	if i < 0 {
+		rootsMu.Unlock()
+		return fmt.Errorf("未知的根目录 %s", name)
+	}
+	if len(roots) == 1 {
+		rootsMu.Unlock()
+		return errors.New("至少需要保留一个根目录")
+	}
+	root := roots[i]
+	roots = slices.Delete(slices.Clone(roots), i, i+1)
+	rootsMu.Unlock()
+
+	releaseRootIndex(root)
+	return nil
+}
+
+// pauseRoot 暂停或恢复根目录。暂停时释放索引，恢复时重新构建
+func pauseRoot(name string, paused bool) (*Root, error) {
+	rootsMu.Lock()
+	i := slices.IndexFunc(roots, func(r *Root) bool { return r.Name == name })
+	if i < 0 {
+		rootsMu.Unlock()
+		return nil, fmt.Errorf("未知的根目录 %s", name)
+	}
+	old := roots[i]
+	root := new(Root)
+	*root = *old
+	root.Paused = paused
+	roots = slices.Clone(roots)
+	roots[i] = root
+	rootsMu.Unlock()
+
+	if paused && !old.Paused {
+		releaseRootIndex(root)
+	}
+	if !paused && old.Paused {
+		go buildRootIndex(root)
+	}
+	return root, nil
+}
+
+// buildRootIndex 构建根目录的索引，失败时记录日志。
+// 构建期间根目录被移除或暂停时释放刚建好的索引
+func buildRootIndex(root *Root) {
+	if _, err := getIndex(root.Path); err != nil {
+		log.Printf("构建索引失败 %s: %v", root.Name, err)
+		return
+	}
+	if findRoot(root.Name) != root {
+		releaseRootIndex(root)
+	}
+}
+
+// releaseRootIndex 在没有其他启用的根目录使用同一路径时释放索引
+func releaseRootIndex(root *Root) {
+	rootsMu.RLock()
+	shared := slices.ContainsFunc(roots, func(r *Root) bool {
+		return r.Path == root.Path && !r.Paused
+	})
+	rootsMu.RUnlock()
+
+	if !shared {
+		dropIndex(root.Path, nil)
+	}
+}
+
+// isRootPath 判断路径是否为某个根目录本身
+func isRootPath(path string) bool {
+	rootsMu.RLock()
+	defer rootsMu.RUnlock()
+	return slices.ContainsFunc(roots, func(r *Root) bool { return r.Path == path })
+}
+
+// pausedRootOf 返回包含该路径的已暂停根目录
+func pausedRootOf(real string) *Root {
+	rootsMu.RLock()
+	defer rootsMu.RUnlock()
+	for _, r := range roots {
+		if r.Paused && withinDir(r.Path, real) {
+			return r
+		}
+	}
+	return nil
+}
+
+// activeRootOf 返回包含该路径的未暂停根目录的路径，有嵌套时返回最内层的，不在任何根目录之下时返回空
+func activeRootOf(real string) string {
+	rootsMu.RLock()
+	defer rootsMu.RUnlock()
+	found := ""
+	for _, r := range roots {
+		if !r.Paused && withinDir(r.Path, real) && len(r.Path) > len(found) {
+			found = r.Path
+		}
+	}
+	return found
+}
+
+// resolveDir 返回目录解析符号链接后的绝对路径
+func resolveDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(real)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s 不是目录", dir)
+	}
+	return real, nil
+}
+
+// isAllowed 判断已解析的真实路径是否位于某个根目录或额外允许的目录之下
+func isAllowed(real string) bool {
+	rootsMu.RLock()
+	defer rootsMu.RUnlock()
+
+	for _, root := range roots {
+		if withinDir(root.Path, real) {
+			return true
+		}
+	}
+	for _, dir := range allowedRoots {
+		if withinDir(dir, real) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveAllowedDir 解析客户端给出的目录，相对路径基于默认根目录。
+// 先按清理后的绝对路径检查范围再访问文件系统，范围之外的路径无论是否存在都返回 errForbidden。
+// 返回解析符号链接后的真实路径
+func resolveAllowedDir(dir string) (string, error) {
+	if dir == "" {
+		return defaultRoot().Path, nil
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(defaultRoot().Path, dir)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil || !isAllowed(abs) {
+		return "", errForbidden
+	}
+
+	real, err := resolveDir(abs)
+	if err != nil {
+		if linkEscapes(abs) {
+			return "", errForbidden
+		}
+		return "", err
+	}
+	if !isAllowed(real) {
+		return "", errForbidden
+	}
+	return real, nil
+}
+
+// linkEscapes 判断无法解析的路径是否可能经符号链接离开允许范围：
+// 向上找到第一个能解析的路径，它不在范围内或途中有无法解析的符号链接时视为越界
+func linkEscapes(path string) bool {
+	for p := path; ; p = filepath.Dir(p) {
+		if real, err := filepath.EvalSymlinks(p); err == nil {
+			return !isAllowed(real)
+		}
+		if info, err := os.Lstat(p); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+		if p == filepath.Dir(p) {
+			return true
+		}
+	}
+}
+
+// resolveAllowedFile 解析 dir 下的文件路径，先按路径检查范围，解析符号链接后必须仍在允许范围内
+func resolveAllowedFile(dir, file string) (string, error) {
+	realDir, err := resolveAllowedDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(realDir, file)
+	if !isAllowed(path) {
+		return "", errForbidden
+	}
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		if linkEscapes(path) {
+			return "", errForbidden
+		}
+		return "", err
+	}
+	if !isAllowed(real) {
+		return "", errForbidden
+	}
+	return real, nil
+}
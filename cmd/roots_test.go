@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useRoots 在测试期间替换根目录列表，结束后恢复
+func useRoots(t *testing.T, list ...*Root) {
+	t.Helper()
+	rootsMu.Lock()
+	savedRoots, savedAllowed := roots, allowedRoots
+	roots, allowedRoots = list, nil
+	rootsMu.Unlock()
+	t.Cleanup(func() {
+		rootsMu.Lock()
+		roots, allowedRoots = savedRoots, savedAllowed
+		rootsMu.Unlock()
+	})
+}
+
+// symlink 创建符号链接，系统不支持时跳过测试
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("无法创建符号链接: %v", err)
+	}
+}
+
+func TestResolveAllowedDir(t *testing.T) {
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, base, "data/sub/a.txt", "data2/secret/b.txt", "out/dir/c.txt")
+	data := filepath.Join(base, "data")
+	symlink(t, filepath.Join(base, "out"), filepath.Join(data, "escape"))
+	symlink(t, filepath.Join(base, "out", "missing"), filepath.Join(data, "dangling"))
+	symlink(t, "sub", filepath.Join(data, "inner"))
+	useRoots(t, &Root{Name: "data", Path: data})
+
+	tests := []struct {
+		dir  string
+		want string // 为空时期望出错
+		deny bool   // 期望 errForbidden
+	}{
+		{dir: "", want: data},
+		{dir: "sub", want: filepath.Join(data, "sub")},
+		{dir: filepath.Join(data, "sub"), want: filepath.Join(data, "sub")},
+		{dir: filepath.Join(data, "inner"), want: filepath.Join(data, "sub")},
+		{dir: filepath.Join(data, "missing")},
+		{dir: filepath.Join(data, "sub", "a.txt")},
+		// /data 不包含 /data2，存在与否都不应泄露
+		{dir: filepath.Join(base, "data2"), deny: true},
+		{dir: filepath.Join(base, "data2", "secret"), deny: true},
+		{dir: filepath.Join(base, "data2", "missing"), deny: true},
+		{dir: filepath.Join(base, "data2", "secret", "b.txt"), deny: true},
+		{dir: "../data2/secret", deny: true},
+		{dir: filepath.Join(data, "..", "data2"), deny: true},
+		{dir: filepath.Join(base, "missing"), deny: true},
+		// 经符号链接离开根目录
+		{dir: filepath.Join(data, "escape"), deny: true},
+		{dir: filepath.Join(data, "escape", "dir"), deny: true},
+		{dir: filepath.Join(data, "escape", "missing"), deny: true},
+		{dir: filepath.Join(data, "dangling"), deny: true},
+	}
+	for _, tt := range tests {
+		got, err := resolveAllowedDir(tt.dir)
+		switch {
+		case tt.deny:
+			if !errors.Is(err, errForbidden) {
+				t.Errorf("resolveAllowedDir(%q) = %q, %v，期望 errForbidden", tt.dir, got, err)
+			}
+		case tt.want == "":
+			if err == nil || errors.Is(err, errForbidden) {
+				t.Errorf("resolveAllowedDir(%q) = %q, %v，期望根目录内的其他错误", tt.dir, got, err)
+			}
+		case err != nil || got != tt.want:
+			t.Errorf("resolveAllowedDir(%q) = %q, %v，期望 %q", tt.dir, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolveAllowedFile(t *testing.T) {
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, base, "data/a.txt", "data2/b.txt", "out/c.txt")
+	data := filepath.Join(base, "data")
+	symlink(t, filepath.Join(base, "out"), filepath.Join(data, "escape"))
+	symlink(t, filepath.Join(base, "out", "c.txt"), filepath.Join(data, "c.txt"))
+	useRoots(t, &Root{Name: "data", Path: data})
+
+	if got, err := resolveAllowedFile(data, "a.txt"); err != nil || got != filepath.Join(data, "a.txt") {
+		t.Errorf("a.txt = %q, %v", got, err)
+	}
+	if _, err := resolveAllowedFile(data, "missing.txt"); err == nil || errors.Is(err, errForbidden) {
+		t.Errorf("根目录内不存在的文件应返回其他错误，得到 %v", err)
+	}
+	for _, file := range []string{"../data2/b.txt", "../data2/missing.txt", "escape/c.txt", "escape/missing.txt", "c.txt"} {
+		if got, err := resolveAllowedFile(data, file); !errors.Is(err, errForbidden) {
+			t.Errorf("%s = %q, %v，期望 errForbidden", file, got, err)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/junegunn/fzf/src/algo"
+	"github.com/junegunn/fzf/src/util"
+)
+
+// matchTerm 是查询中的一个词，语法与 fzf 的扩展搜索模式相同
+type matchTerm struct {
+	fn            algo.Algo
+	inverse       bool
+	caseSensitive bool
+	normalize     bool
+	text          []rune
+}
+
+// queryMatcher 用 fzf 的匹配算法为 fzf 输出的结果重新计算得分和匹配位置。
+// fzf 的 Go API 只输出匹配的行，不包含得分和位置
+type queryMatcher struct {
+	sets     [][]matchTerm // 组之间是“与”的关系，同一组内以 | 分隔的词是“或”的关系
+	criteria []string      // 生效的 tiebreak 依据
+	forward  bool
+	withPos  bool // 与 fzf 相同，tiebreak 用到匹配位置时匹配算法才回溯位置，影响匹配区间的起点
+	sortable bool // 有非反向匹配的词，只有反向匹配的词时 fzf 不排序
+	slab     *util.Slab
+}
+
+// matchSpan 是各组匹配区间的范围，fzf 用它计算 tiebreak。
+// 只有反向匹配的词成立的组没有区间，valid 为 false 表示所有组都没有区间
+type matchSpan struct {
+	minBegin, minEnd, maxEnd int
+	valid                    bool
+}
+
+var querySplitRegex = regexp.MustCompile(" +")
+
+// fzfGate 保护 fzf 包中的全局状态：algo 包中由评分方案决定的加分表和字符分类，以及 fzf 的排序依据。
+// 评分方案是服务端的设置，第一次匹配前按 defaultMatch.Scheme 设置一次，之后不再切换：
+// algo.Init 切换方案时只重写部分表，切换后的得分与新启动的 fzf 不同。
+// 只读取这些状态的匹配可以同时进行；fzf.Run 启动时总会重写它们（值与当前相同），结束时也会重置 fzf 的全局状态，
+// 因此运行 fzf.Run 的搜索在 fzf 启动期间独占，启动完成后转为共享，同一时间只运行一个 fzf.Run。
+// 有搜索等待独占时新到达的匹配也排队，独占不会被一直推迟
+type fzfGate struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	once    sync.Once
+	readers int  // 共享中的匹配数
+	writing bool // 有搜索独占
+	waiting int  // 等待独占的搜索数
+}
+
+var fzfGlobals = newFzfGate()
+
+func newFzfGate() *fzfGate {
+	g := &fzfGate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// init 按服务端的评分方案设置加分表，只设置一次。调用方需持有锁
+func (g *fzfGate) init() {
+	g.once.Do(func() {
+		algo.Init(defaultMatch.scheme())
+	})
+}
+
+// share 等到没有搜索独占或等待独占时开始共享，返回释放函数。所有使用 fzf 匹配算法的搜索在匹配期间都要持有
+func (g *fzfGate) share() (release func()) {
+	g.mu.Lock()
+	for g.writing || g.waiting > 0 {
+		g.cond.Wait()
+	}
+	g.init()
+	g.readers++
+	g.mu.Unlock()
+
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.readers--; g.readers == 0 {
+			g.cond.Broadcast()
+		}
+	}
+}
+
+// lock 等到其他匹配全部结束后独占，供运行 fzf.Run 的搜索使用。
+// share 将独占转为共享，release 释放独占或共享，都只能调用一次
+func (g *fzfGate) lock() (share, release func()) {
+	g.mu.Lock()
+	g.waiting++
+	for g.writing || g.readers > 0 {
+		g.cond.Wait()
+	}
+	g.waiting--
+	g.init()
+	g.writing = true
+	g.mu.Unlock()
+
+	shared := false
+	share = func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		shared, g.writing = true, false
+		g.readers++
+		g.cond.Broadcast()
+	}
+	release = func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if shared {
+			g.readers--
+		} else {
+			g.writing = false
+		}
+		g.cond.Broadcast()
+	}
+	return share, release
+}
+
+// newQueryMatcher 按与 fzf 相同的规则解析查询。得分取决于评分方案，匹配时需共享 fzfGlobals
+func newQueryMatcher(query string, opts MatchOptions) *queryMatcher {
+	fuzzy := algo.FuzzyMatchV2
+	if opts.Algo == "v1" {
+		fuzzy = algo.FuzzyMatchV1
+	}
+	exact := opts.Exact != nil && *opts.Exact
+	normalize := opts.Literal == nil || !*opts.Literal
+
+	m := &queryMatcher{
+		criteria: tiebreakCriteria(opts),
+		slab:     util.MakeSlab(100*1024, 2048),
+	}
+	m.forward, m.withPos = tiebreakMode(m.criteria)
+
+	query = strings.ReplaceAll(strings.TrimLeft(query, " "), "\\ ", "\t")
+	var set []matchTerm
+	switchSet, afterBar := false, false
+	for _, token := range querySplitRegex.Split(query, -1) {
+		text := strings.ReplaceAll(token, "\t", " ")
+		lowerText := strings.ToLower(text)
+		caseSensitive := opts.Case == "respect" ||
+			(opts.Case == "" || opts.Case == "smart") && text != lowerText
+		if !caseSensitive {
+			text = lowerText
+		}
+
+		if len(set) > 0 && !afterBar && text == "|" {
+			switchSet = false
+			afterBar = true
+			continue
+		}
+		afterBar = false
+
+		t := matchTerm{fn: fuzzy, caseSensitive: caseSensitive}
+		if exact {
+			t.fn = algo.ExactMatchNaive
+		}
+
+		if strings.HasPrefix(text, "!") {
+			t.inverse = true
+			t.fn = algo.ExactMatchNaive
+			text = text[1:]
+		}
+
+		suffix := text != "$" && strings.HasSuffix(text, "$")
+		if suffix {
+			t.fn = algo.SuffixMatch
+			text = text[:len(text)-1]
+		}
+
+		switch {
+		case len(text) > 2 && strings.HasPrefix(text, "'") && strings.HasSuffix(text, "'"):
+			t.fn = algo.ExactMatchBoundary
+			text = text[1 : len(text)-1]
+		case strings.HasPrefix(text, "'"):
+			// ' 切换精确和模糊匹配
+			if !exact && !t.inverse {
+				t.fn = algo.ExactMatchNaive
+			} else {
+				t.fn = fuzzy
+			}
+			text = text[1:]
+		case strings.HasPrefix(text, "^"):
+			if suffix {
+				t.fn = algo.EqualMatch
+			} else {
+				t.fn = algo.PrefixMatch
+			}
+			text = text[1:]
+		}
+
+		if text == "" {
+			continue
+		}
+		if switchSet {
+			m.sets = append(m.sets, set)
+			set = nil
+		}
+		t.text = []rune(text)
+		t.normalize = normalize && lowerText == string(algo.NormalizeRunes([]rune(lowerText)))
+		if t.normalize {
+			t.text = algo.NormalizeRunes(t.text)
+		}
+		set = append(set, t)
+		switchSet = true
+		m.sortable = m.sortable || !t.inverse
+	}
+	if len(set) > 0 {
+		m.sets = append(m.sets, set)
+	}
+	return m
+}
+
+// tiebreakMode 与 fzf 相同：最靠前的 begin、end 或 pathname 决定匹配方向，
+// end 和 pathname 从后向前匹配；有 chunk 或 pathname 时回溯匹配位置
+func tiebreakMode(criteria []string) (forward, withPos bool) {
+	forward = true
+	for i := len(criteria) - 1; i >= 0; i-- {
+		switch criteria[i] {
+		case "chunk":
+			withPos = true
+		case "end":
+			forward = false
+		case "begin":
+			forward = true
+		case "pathname":
+			forward, withPos = false, true
+		}
+	}
+	return forward, withPos
+}
+
+// clone 返回共用查询、使用独立缓冲区的匹配器，供多个 goroutine 并行匹配
+func (m *queryMatcher) clone() *queryMatcher {
+	c := *m
+	c.slab = util.MakeSlab(100*1024, 2048)
+	return &c
+}
+
+// Match 返回文本的得分、匹配字符的下标（按字符计，已排序）和 fzf 排序用的比较值。
+// 用于 fzf 已经判定匹配的行
+func (m *queryMatcher) Match(text string) (int, []int, [4]uint16) {
+	chars := util.ToChars([]byte(text))
+	_, score, span, positions := m.match(&chars, true)
+	if !m.withPos && (slices.Contains(m.criteria, "begin") || slices.Contains(m.criteria, "end")) {
+		// fzf 不回溯位置时匹配区间的起点可能不同，begin 和 end 用到起点
+		_, _, span, _ = m.match(&chars, false)
+	}
+	return score, positions, m.points(&chars, score, span)
+}
+
+// rank 按与 fzf 相同的方式匹配文本，返回得分和排序用的比较值
+func (m *queryMatcher) rank(chars *util.Chars) (ok bool, score int, points [4]uint16) {
+	ok, score, span, _ := m.match(chars, m.withPos)
+	if !ok {
+		return false, 0, points
+	}
+	return true, score, m.points(chars, score, span)
+}
+
+// points 返回排序用的比较值，只有反向匹配的词时全为 0，只按候选的顺序排列
+func (m *queryMatcher) points(chars *util.Chars, score int, span matchSpan) [4]uint16 {
+	if !m.sortable {
+		return [4]uint16{}
+	}
+	return tiebreakPoints(m.criteria, chars, score, span)
+}
+
+// match 判断文本是否匹配，并返回得分、匹配区间和匹配字符的下标，规则与 fzf 相同：
+// 每组取第一个匹配的非反向词；反向匹配的词不匹配时该组成立，但不计分、没有区间，仍继续尝试组内之后的词。
+// withPos 为 false 时不计算位置，模糊匹配返回的起点也不精确
+func (m *queryMatcher) match(chars *util.Chars, withPos bool) (ok bool, score int, span matchSpan, positions []int) {
+	span = matchSpan{minBegin: math.MaxUint16, minEnd: math.MaxUint16}
+	for _, set := range m.sets {
+		matched := false
+		var begin, end, setScore int
+		for _, t := range set {
+			res, pos := t.fn(t.caseSensitive, t.normalize, m.forward, chars, t.text, withPos, m.slab)
+			if res.Start >= 0 {
+				if t.inverse {
+					continue
+				}
+				matched, begin, end, setScore = true, res.Start, res.End, res.Score
+				switch {
+				case !withPos:
+				case pos != nil:
+					positions = append(positions, *pos...)
+				default:
+					// 精确匹配等算法不返回位置，匹配的是连续区间
+					for i := res.Start; i < res.End; i++ {
+						positions = append(positions, i)
+					}
+				}
+				break
+			}
+			if t.inverse {
+				matched, begin, end, setScore = true, 0, 0, 0
+			}
+		}
+		if !matched {
+			return false, 0, matchSpan{}, nil
+		}
+		score += setScore
+		if begin < end {
+			span.minBegin = min(span.minBegin, begin)
+			span.minEnd = min(span.minEnd, end)
+			span.maxEnd = max(span.maxEnd, end)
+			span.valid = true
+		}
+	}
+	slices.Sort(positions)
+	return true, score, span, slices.Compact(positions)
+}
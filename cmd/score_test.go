@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// waitFor 等待条件成立，超时后测试失败
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待超时: %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFzfGate(t *testing.T) {
+	g := newFzfGate()
+	waiting := func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.waiting > 0
+	}
+	blocked := func(what string, ch chan func()) {
+		t.Helper()
+		select {
+		case <-ch:
+			t.Fatal(what)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// 共享的匹配可以同时进行
+	release1 := g.share()
+	release2 := g.share()
+
+	// 独占要等共享的匹配全部结束
+	lockIn := make(chan func())
+	var share func()
+	go func() {
+		s, release := g.lock()
+		share = s
+		lockIn <- release
+	}()
+	waitFor(t, "开始等待独占", waiting)
+
+	// 有搜索等待独占时，新的共享也要排队
+	shareIn := make(chan func())
+	go func() { shareIn <- g.share() }()
+	release1()
+	blocked("仍有共享的匹配时开始了独占", lockIn)
+	release2()
+	releaseLock := <-lockIn
+	blocked("独占期间开始了共享的匹配", shareIn)
+
+	// 转为共享后其他匹配可以进行，其他独占仍要等待
+	share()
+	releaseShared := <-shareIn
+	otherIn := make(chan func())
+	go func() {
+		_, release := g.lock()
+		otherIn <- release
+	}()
+	waitFor(t, "第二个独占开始等待", waiting)
+	releaseShared()
+	blocked("转为共享的搜索结束前开始了另一个独占", otherIn)
+	releaseLock()
+	(<-otherIn)()
+
+	if g.readers != 0 || g.writing {
+		t.Errorf("全部释放后 readers = %d，writing = %v", g.readers, g.writing)
+	}
+}
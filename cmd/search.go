@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/junegunn/fzf/src/util"
+)
+
+// searchTarget 是一次搜索涉及的一个目录
+type searchTarget struct {
+	Name    string       // 根目录名，使用 baseDir 参数时为空
+	Dir     string       // 解析符号链接后的绝对路径
+	Options MatchOptions // 根目录默认的匹配选项
+}
+
+// candidateSet 是某个目录下参与匹配的候选及其来源
+type candidateSet struct {
+	searchTarget
+	Files []string   // 按路径排序
+	Meta  []fileMeta // 与 Files 对应的文件信息，为 nil 时生成结果时读取文件
+	Stats walkStats
+	Index *IndexStatus // 直接遍历目录时为 nil，Generation 是 Files 对应的 generation
+
+	chars []util.Chars // 与 Files 对应的 fzf 字符表示，为 nil 时由匹配器按需转换
+}
+
+// targets 返回请求要搜索的目录：指定的根目录、兼容的 baseDir 参数或默认根目录。
+// 未知的根目录名和不在允许范围内的目录返回 errForbidden
+func (req *SearchRequest) targets() ([]searchTarget, error) {
+	if len(req.Roots) > 0 {
+		var targets []searchTarget
+		seen := map[string]bool{}
+		for _, name := range req.Roots {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			root := findRoot(name)
+			if root == nil {
+				return nil, fmt.Errorf("%w: 未知的根目录 %s", errForbidden, name)
+			}
+			if root.Paused {
+				return nil, fmt.Errorf("%w: %s", errPaused, name)
+			}
+			targets = append(targets, searchTarget{Name: root.Name, Dir: root.Path, Options: root.Options})
+		}
+		return targets, nil
+	}
+
+	if req.BaseDir == "" {
+		root := defaultRoot()
+		if root.Paused {
+			return nil, fmt.Errorf("%w: %s", errPaused, root.Name)
+		}
+		return []searchTarget{{Name: root.Name, Dir: root.Path, Options: root.Options}}, nil
+	}
+
+	dir, err := resolveAllowedDir(req.BaseDir)
+	if err != nil {
+		return nil, err
+	}
+	if root := pausedRootOf(dir); root != nil {
+		return nil, fmt.Errorf("%w: %s", errPaused, root.Name)
+	}
+	return []searchTarget{{Dir: dir}}, nil
+}
+
+// matchOptions 返回请求的匹配选项：请求中的选项覆盖根目录默认值，再覆盖服务端默认值。
+// 同时搜索多个根目录时在同一个 fzf 中匹配，使用第一个根目录的默认值
+func (req *SearchRequest) matchOptions(targets []searchTarget) (MatchOptions, error) {
+	opts := defaultMatch.merge(targets[0].Options).merge(req.Options)
+	// 兼容参数 useAPI: false 表示使用 fzf 程序
+	if req.UseAPI != nil && req.Options.Engine == "" {
+		opts.Engine = engineFzf
+		if !*req.UseAPI {
+			opts.Engine = engineFzfBinary
+		}
+	}
+	if err := req.Options.checkScheme(); err != nil {
+		return opts, err
+	}
+	_, err := opts.args()
+	return opts, err
+}
+
+// sortKeys 是除相关度外可用的排序方式
+var sortKeys = []string{"name", "size", "mtime", "ext", "mime", "owner"}
+
+// sortResults 按名称、大小、修改时间等文件信息重新排序，相同的结果保持相关度顺序。
+// key 为空或 score 时保持 fzf 的相关度顺序
+func sortResults(results []SearchResult, key string, desc bool) error {
+	var cmp func(a, b *SearchResult) int
+	switch key {
+	case "", "score":
+		if desc {
+			slices.Reverse(results)
+		}
+		return nil
+	case "name":
+		cmp = func(a, b *SearchResult) int {
+			return cmpOr(strings.Compare(a.Filename, b.Filename), strings.Compare(a.Path, b.Path))
+		}
+	case "size":
+		cmp = func(a, b *SearchResult) int { return cmpInt(a.Size, b.Size) }
+	case "mtime":
+		cmp = func(a, b *SearchResult) int { return a.ModTime.Compare(b.ModTime) }
+	case "ext":
+		cmp = func(a, b *SearchResult) int { return strings.Compare(a.Ext, b.Ext) }
+	case "mime":
+		cmp = func(a, b *SearchResult) int { return strings.Compare(a.MIME, b.MIME) }
+	case "owner":
+		cmp = func(a, b *SearchResult) int {
+			return cmpOr(strings.Compare(a.Owner, b.Owner), strings.Compare(a.Group, b.Group))
+		}
+	default:
+		return fmt.Errorf("无效的排序方式: %q，可选 score、%s", key, strings.Join(sortKeys, "、"))
+	}
+
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		if desc {
+			return cmp(&b, &a)
+		}
+		return cmp(&a, &b)
+	})
+	return nil
+}
+
+func cmpOr(a, b int) int {
+	if a != 0 {
+		return a
+	}
+	return b
+}
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// searchError 是需要以特定 HTTP 状态码返回的搜索错误
+type searchError struct {
+	status int
+	msg    string
+}
+
+func (e *searchError) Error() string {
+	return e.msg
+}
+
+// searchPlan 是校验后的搜索请求及其候选
+type searchPlan struct {
+	sets     []*candidateSet
+	opts     MatchOptions
+	searcher Searcher
+	indexes  []*IndexStatus
+	start    time.Time
+}
+
+// plan 校验请求并获取各目录的候选，不执行匹配
+func (req *SearchRequest) plan(ctx context.Context) (*searchPlan, error) {
+	p := &searchPlan{start: time.Now()}
+	if err := req.parseQuery(); err != nil {
+		return nil, err
+	}
+
+	// 要搜索的目录必须是已配置的根目录或位于允许的根目录之下
+	targets, err := req.targets()
+	if errors.Is(err, errForbidden) {
+		return nil, &searchError{http.StatusForbidden, "禁止访问: " + err.Error()}
+	}
+	if errors.Is(err, errPaused) {
+		return nil, &searchError{http.StatusServiceUnavailable, err.Error()}
+	}
+	if err != nil {
+		return nil, errors.New("目录不存在: " + req.BaseDir)
+	}
+
+	p.opts, err = req.matchOptions(targets)
+	if err != nil {
+		return nil, &searchError{http.StatusBadRequest, "无效的匹配选项: " + err.Error()}
+	}
+	p.searcher = searcherFor(p.opts)
+	if err := sortResults(nil, req.Sort, req.Desc); err != nil {
+		return nil, &searchError{http.StatusBadRequest, err.Error()}
+	}
+	if err := req.Filter.validate(); err != nil {
+		return nil, &searchError{http.StatusBadRequest, "无效的过滤条件: " + err.Error()}
+	}
+
+	// 按本次请求的遍历策略获取各目录的候选，过滤后再交给搜索引擎
+	policy := req.walkPolicy()
+	for _, t := range targets {
+		set, err := loadCandidates(ctx, t, policy)
+		if err != nil {
+			return nil, err
+		}
+		if !req.Filter.empty() {
+			set = req.Filter.apply(set)
+		}
+		p.sets = append(p.sets, set)
+		if set.Index != nil {
+			p.indexes = append(p.indexes, set.Index)
+		}
+	}
+	return p, nil
+}
+
+// response 生成不含结果的响应，total 是匹配总数
+func (p *searchPlan) response(total int) *SearchResponse {
+	stats := mergeStats(p.sets)
+	return &SearchResponse{
+		Total:     total,
+		Indexes:   p.indexes,
+		Warnings:  stats.Warnings,
+		Scanned:   stats.Scanned,
+		Skipped:   stats.Skipped,
+		Truncated: stats.Truncated,
+		Stale:     slices.ContainsFunc(p.indexes, func(s *IndexStatus) bool { return s.Stale }),
+		ElapsedMs: time.Since(p.start).Milliseconds(),
+	}
+}
+
+// search 执行请求的搜索并返回排序后的结果集。ctx 取消时停止遍历和匹配并返回 ctx 的错误
+func (req *SearchRequest) search(ctx context.Context) (*resultSnapshot, error) {
+	p, err := req.plan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 执行搜索
+	results, err := collectResults(ctx, p.searcher, req.Query, p.sets, p.opts)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, errors.New("搜索失败: " + err.Error())
+	}
+	sortResults(results, req.Sort, req.Desc)
+	return newResultSnapshot(p, req.Query, results), nil
+}
+
+// loadCandidates 返回目录下的候选。策略与默认相同时使用所在根目录的索引（首次访问时构建），
+// 否则直接遍历目录，ctx 取消时停止遍历。索引由多个请求共用，构建不会被取消。
+// 只为根目录本身建立索引，根目录下的子目录从根目录的索引中按路径前缀截取，
+// 不为客户端给出的每个目录建立常驻索引和监听
+func loadCandidates(ctx context.Context, t searchTarget, policy WalkPolicy) (*candidateSet, error) {
+	set := &candidateSet{searchTarget: t}
+
+	// 限制深度时子目录的深度从子目录算起，与根目录的索引不同，直接遍历
+	root := ""
+	if policy.Equal(defaultPolicy) {
+		root = activeRootOf(t.Dir)
+		if root != t.Dir && policy.MaxDepth > 0 {
+			root = ""
+		}
+	}
+	if root != "" {
+		idx, err := getIndex(root)
+		if err != nil {
+			return nil, fmt.Errorf("索引构建失败: %v", err)
+		}
+		var gen uint64
+		set.Files, set.Meta, gen = idx.Candidates()
+		if root != t.Dir {
+			set.Files, set.Meta = subdirCandidates(root, t.Dir, set.Files, set.Meta)
+		}
+		set.Stats = idx.Stats()
+		set.Index = idx.Status()
+		set.Index.Name = t.Name
+		set.Index.Generation = gen // 候选列表的 generation，索引可能已经更新
+		return set, nil
+	}
+
+	res, err := walkFiles(ctx, t.Dir, policy)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("遍历目录失败: %v", err)
+	}
+	res.sort()
+	set.Files, set.Meta = res.Files, res.Meta
+	set.Stats = res.walkStats
+	return set, nil
+}
+
+// subdirCandidates 从根目录 root 按路径排序的候选中截取子目录 dir 下的部分，路径改为相对于 dir。
+// 同一前缀的路径在排序后是连续的，去掉前缀后仍然有序；文件信息与根目录的索引共用
+func subdirCandidates(root, dir string, files []string, meta []fileMeta) ([]string, []fileMeta) {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return nil, nil
+	}
+	prefix := rel + string(filepath.Separator)
+	lo, _ := slices.BinarySearch(files, prefix)
+	hi := lo
+	for hi < len(files) && strings.HasPrefix(files[hi], prefix) {
+		hi++
+	}
+
+	sub := make([]string, hi-lo)
+	for i, file := range files[lo:hi] {
+		sub[i] = file[len(prefix):]
+	}
+	if meta != nil {
+		meta = meta[lo:hi:hi]
+	}
+	return sub, meta
+}
+
+// mergeStats 汇总多个目录的遍历统计，多个目录时警告前加上根目录名
+func mergeStats(sets []*candidateSet) walkStats {
+	var stats walkStats
+	for _, set := range sets {
+		stats.Scanned += set.Stats.Scanned
+		stats.Skipped += set.Stats.Skipped
+		stats.Truncated = stats.Truncated || set.Stats.Truncated
+		for _, warning := range set.Stats.Warnings {
+			if len(stats.Warnings) >= maxWarnings {
+				break
+			}
+			if len(sets) > 1 {
+				warning = "[" + set.Name + "] " + warning
+			}
+			stats.Warnings = append(stats.Warnings, warning)
+		}
+	}
+	return stats
+}
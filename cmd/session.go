@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// SessionMessage 是客户端在搜索会话中发送的消息。
+// query 消息包含完整的搜索请求，page 消息只使用 Offset、Limit 和 Verify，从上一次的结果中取一页
+type SessionMessage struct {
+	Type string `json:"type"` // query 或 page
+	Seq  int    `json:"seq"`  // 客户端的请求序号，原样返回
+	SearchRequest
+}
+
+// SessionResult 是服务端推送的一页结果，内容与 /api/search 的响应相同，但没有 snapshotId，
+// 翻页使用 page 消息
+type SessionResult struct {
+	Type string `json:"type"` // results 或 error
+	Seq  int    `json:"seq"`
+	*SearchResponse
+}
+
+// 只接受同源的连接，跨域页面不能借用户的浏览器访问文件列表
+var upgrader = websocket.Upgrader{}
+
+// maxSessionMessage 是客户端消息的最大字节数
+const maxSessionMessage = 64 * 1024
+
+// searchSession 保存一个连接的候选和上一次的结果，在查询之间复用：
+// 遍历参数和过滤条件不变且索引没有变化时不重新加载候选，
+// 查询只是在上一次的基础上追加字符时只在上一次匹配的候选中搜索，与 fzf 的缓存相同
+type searchSession struct {
+	conn *websocket.Conn
+
+	key  string      // 候选对应的根目录和遍历策略
+	plan *searchPlan // 已加载的候选
+
+	query  string          // 上一次完成的查询
+	opts   MatchOptions    // 上一次完成的查询的匹配选项
+	ranked []SearchResult  // 上一次匹配的结果，按相关度排序
+	snap   *resultSnapshot // 上一次的结果集，用于翻页。只属于本会话，不放入 resultSnapshots
+}
+
+// handleSearchSession 在 WebSocket 连接上保持一个搜索会话。
+// 新的查询到达时取消仍在进行的查询，每次查询完成后推送排序后的一页结果
+func handleSearchSession(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade 已返回错误响应
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxSessionMessage)
+
+	// 读取消息的 goroutine 只负责接收，连接关闭时关闭 msgs
+	msgs := make(chan SessionMessage)
+	go func() {
+		defer close(msgs)
+		for {
+			var msg SessionMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}()
+
+	// 同一时间只处理一条消息，写入连接的只有处理消息的 goroutine
+	s := &searchSession{conn: conn}
+	cancel := context.CancelFunc(func() {})
+	running := make(chan struct{})
+	close(running)
+	for msg := range msgs {
+		cancel()
+		<-running
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(r.Context())
+		running = make(chan struct{})
+		go func() {
+			defer close(running)
+			s.handle(ctx, msg)
+		}()
+	}
+	cancel()
+	<-running
+}
+
+// handle 处理一条消息，被取消时不推送结果
+func (s *searchSession) handle(ctx context.Context, msg SessionMessage) {
+	var (
+		resp *SearchResponse
+		err  error
+	)
+	switch msg.Type {
+	case "query":
+		resp, err = s.search(ctx, &msg.SearchRequest)
+	case "page":
+		if s.snap == nil {
+			err = errors.New("还没有搜索结果")
+			break
+		}
+		resp, err = s.snap.page(msg.Offset, msg.Limit)
+	default:
+		err = fmt.Errorf("无效的消息类型: %q", msg.Type)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		s.conn.WriteJSON(SessionResult{Type: "error", Seq: msg.Seq, SearchResponse: &SearchResponse{Error: err.Error()}})
+		return
+	}
+	resp.fill(msg.Verify)
+	s.conn.WriteJSON(SessionResult{Type: "results", Seq: msg.Seq, SearchResponse: resp})
+}
+
+// search 执行查询，尽量复用上一次的候选和结果
+func (s *searchSession) search(ctx context.Context, req *SearchRequest) (*SearchResponse, error) {
+	if _, err := pageLimit(req.Offset, req.Limit); err != nil {
+		return nil, err
+	}
+	// 查询中的运算符并入过滤条件，之后只比较交给搜索引擎的部分
+	if err := req.parseQuery(); err != nil {
+		return nil, err
+	}
+
+	// 根目录、遍历策略或过滤条件变化、索引有更新时重新加载候选
+	filter, _ := json.Marshal(req.Filter)
+	key := fmt.Sprintf("%q %q %+v %s", req.Roots, req.BaseDir, req.walkPolicy(), filter)
+	if key != s.key || s.plan.changed() {
+		p, err := req.plan(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.key, s.plan = key, p
+		s.ranked = nil
+	} else {
+		// 复用候选时仍然校验本次请求的匹配选项和排序方式
+		targets := make([]searchTarget, len(s.plan.sets))
+		for i, set := range s.plan.sets {
+			targets[i] = set.searchTarget
+		}
+		opts, err := req.matchOptions(targets)
+		if err != nil {
+			return nil, &searchError{http.StatusBadRequest, "无效的匹配选项: " + err.Error()}
+		}
+		if err := sortResults(nil, req.Sort, req.Desc); err != nil {
+			return nil, err
+		}
+		s.plan.opts = opts
+		s.plan.searcher = searcherFor(opts)
+	}
+
+	p := s.plan
+	switch {
+	case s.ranked != nil && req.Query == s.query && p.opts.Equal(s.opts):
+		// 只有排序方式或分页变化，不需要重新匹配
+	case s.ranked != nil && p.opts.Equal(s.opts) && narrows(s.query, req.Query):
+		if err := s.match(ctx, req.Query, narrowSets(p.sets, s.ranked)); err != nil {
+			return nil, err
+		}
+	default:
+		if err := s.match(ctx, req.Query, p.sets); err != nil {
+			return nil, err
+		}
+	}
+
+	results := slices.Clone(s.ranked)
+	sortResults(results, req.Sort, req.Desc)
+	s.snap = newResultSnapshot(p, req.Query, results)
+	return s.snap.page(req.Offset, req.Limit)
+}
+
+// match 在 sets 中匹配查询并保存结果，排序与 /api/search 相同。
+// 缩小范围的查询只匹配上一次的候选，候选的相对顺序不变，结果的顺序与重新搜索相同
+func (s *searchSession) match(ctx context.Context, query string, sets []*candidateSet) error {
+	s.ranked = nil
+	ranked, err := collectResults(ctx, s.plan.searcher, query, sets, s.plan.opts)
+	if err != nil {
+		return err
+	}
+	s.query, s.opts, s.ranked = query, s.plan.opts, ranked
+	return nil
+}
+
+// changed 判断加载候选后是否有索引发生了变化
+func (p *searchPlan) changed() bool {
+	if p == nil {
+		return true
+	}
+	for _, set := range p.sets {
+		if set.Index == nil {
+			continue
+		}
+		idx := lookupIndex(set.Index.Root)
+		if idx == nil {
+			return true
+		}
+		if idx.Generation() != set.Index.Generation {
+			return true
+		}
+	}
+	return false
+}
+
+// narrows 判断 query 的结果是否一定是 prev 的结果的子集：query 在 prev 后追加字符，
+// 且都不含反向匹配、“或”和转义，prev 也不含后缀匹配（"a$" 追加后变为模糊匹配）
+func narrows(prev, query string) bool {
+	return prev != "" && strings.HasPrefix(query, prev) &&
+		!strings.ContainsAny(prev, "!|\\$") && !strings.ContainsAny(query, "!|\\")
+}
+
+// narrowSets 返回只包含上一次匹配的候选的候选集，保持原来的候选顺序和文件信息。
+// 候选不再与索引的候选列表对应，因此不设置 Index
+func narrowSets(sets []*candidateSet, matched []SearchResult) []*candidateSet {
+	byName := map[string]int{}
+	for i, set := range sets {
+		byName[set.Name] = i
+	}
+	picked := make([][]int, len(sets))
+	for _, result := range matched {
+		if i, ok := byName[result.Root]; ok {
+			if j := sets[i].find(result.Path); j >= 0 {
+				picked[i] = append(picked[i], j)
+			}
+		}
+	}
+
+	narrowed := make([]*candidateSet, len(sets))
+	for i, set := range sets {
+		n := &candidateSet{searchTarget: set.searchTarget, Stats: set.Stats}
+		slices.Sort(picked[i])
+		for _, j := range picked[i] {
+			n.Files = append(n.Files, set.Files[j])
+			if set.Meta != nil {
+				n.Meta = append(n.Meta, set.Meta[j])
+			}
+		}
+		narrowed[i] = n
+	}
+	return narrowed
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestSessionNarrowRanking(t *testing.T) {
+	// 缩小范围的查询只匹配上一次的结果，顺序仍与重新搜索相同
+	sets := []*candidateSet{
+		testSet("a", "/a", "src/main.go", "cmd/server/main_test.go", "x/domain.txt", "README.md"),
+		testSet("b", "/b", "Main.go", "mlib/a/in.txt", "src/main.go", "m/a/i/n"),
+	}
+	for _, engine := range conformanceEngines(t) {
+		opts := defaultMatch.merge(MatchOptions{Engine: engine})
+		s := &searchSession{plan: &searchPlan{sets: sets, opts: opts, searcher: searcherFor(opts)}}
+		if err := s.match(context.Background(), "ma", sets); err != nil {
+			t.Fatal(err)
+		}
+		if !narrows("ma", "main") {
+			t.Fatal("main 应缩小 ma 的范围")
+		}
+		if err := s.match(context.Background(), "main", narrowSets(sets, s.ranked)); err != nil {
+			t.Fatal(err)
+		}
+
+		want, err := collectResults(context.Background(), searcherFor(opts), "main", sets, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rootPaths(s.ranked); !slices.Equal(got, rootPaths(want)) {
+			t.Errorf("%s 缩小范围后的结果 = %q，期望与 /api/search 相同 %q", engine, got, rootPaths(want))
+		}
+	}
+}
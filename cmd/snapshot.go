@@ -0,0 +1,229 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+)
+
+var (
+	snapshotDir      string        // 快照目录，为空时不读写快照
+	snapshotInterval time.Duration // 定期保存快照的间隔
+)
+
+// snapshotVersion 在快照格式变化时递增，旧版本的快照会被忽略
+const snapshotVersion = 4
+
+// indexSnapshot 是保存到磁盘的根目录索引
+type indexSnapshot struct {
+	Version  int
+	Root     string // 生成快照时根目录的绝对路径
+	Policy   WalkPolicy
+	MaxFiles int
+	Files    []string
+	Meta     []fileMeta // 与 Files 对应的文件信息
+	Stats    walkStats
+	BuiltAt  time.Time // 快照内容对应的完整遍历时间
+	SavedAt  time.Time
+}
+
+// snapshotFile 返回根目录快照的文件名，只有已配置的根目录才保存快照
+func snapshotFile(root string) string {
+	if snapshotDir == "" {
+		return ""
+	}
+	for _, r := range listRoots() {
+		if r.Path == root {
+			return filepath.Join(snapshotDir, r.Name+".snapshot")
+		}
+	}
+	return ""
+}
+
+// writeSnapshot 将快照写入临时文件后再重命名，避免读到写了一半的快照
+func writeSnapshot(path string, snap *indexSnapshot) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	zw := gzip.NewWriter(f)
+	if err := gob.NewEncoder(zw).Encode(snap); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// buildSnapshot 按默认遍历策略完整遍历目录，生成快照但不写入磁盘
+func buildSnapshot(root string) (*indexSnapshot, error) {
+	w, err := newWalker(root, defaultPolicy, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := w.walk(context.Background(), root)
+	if err != nil {
+		return nil, err
+	}
+	res.sort()
+
+	now := time.Now()
+	return &indexSnapshot{
+		Version:  snapshotVersion,
+		Root:     root,
+		Policy:   defaultPolicy,
+		MaxFiles: maxFiles,
+		Files:    res.Files,
+		Meta:     res.Meta,
+		Stats:    res.walkStats,
+		BuiltAt:  now,
+		SavedAt:  now,
+	}, nil
+}
+
+// readSnapshot 读取快照文件
+func readSnapshot(path string) (*indexSnapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	var snap indexSnapshot
+	if err := gob.NewDecoder(zr).Decode(&snap); err != nil {
+		return nil, err
+	}
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("不支持的快照版本 %d", snap.Version)
+	}
+	return &snap, nil
+}
+
+// loadSnapshot 用快照内容初始化索引。遍历策略或文件数上限不同的快照不能使用
+func (idx *fileIndex) loadSnapshot() bool {
+	path := snapshotFile(idx.root)
+	if path == "" {
+		return false
+	}
+	snap, err := readSnapshot(path)
+	if os.IsNotExist(err) {
+		return false
+	}
+	if err != nil {
+		log.Printf("读取快照失败 %s: %v", path, err)
+		return false
+	}
+	if !snap.Policy.Equal(idx.policy) || snap.MaxFiles != maxFiles {
+		log.Printf("快照 %s 的遍历策略与当前设置不同，已忽略", path)
+		return false
+	}
+	if snap.Root != idx.root {
+		log.Printf("快照 %s 生成于 %s，按相对路径用于 %s", path, snap.Root, idx.root)
+	}
+
+	// 监听事件需要遍历器处理新目录
+	w, err := newWalker(idx.root, idx.policy, idx.watchDir)
+	if err != nil {
+		return false
+	}
+
+	if len(snap.Meta) != len(snap.Files) {
+		log.Printf("快照 %s 的文件信息不完整，已忽略", path)
+		return false
+	}
+	// 快照中的候选已按路径排序，其他程序生成的快照重新排序
+	if !slices.IsSorted(snap.Files) {
+		sort.Sort(filesByPath{snap.Files, snap.Meta})
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.walker = w
+	idx.setFiles(snap.Files, snap.Meta)
+	idx.stats = snap.Stats
+	idx.touch()
+	idx.builtAt = snap.BuiltAt
+	idx.stale = true
+	idx.savedGen = idx.generation
+
+	log.Printf("已从快照加载索引: %s, %d 个文件, 快照时间 %s", idx.root, len(snap.Files), snap.SavedAt.Format(time.DateTime))
+	return true
+}
+
+// saveSnapshot 在索引有变化时保存快照。尚未完成校对的索引不保存，
+// 以免覆盖更完整的快照
+func (idx *fileIndex) saveSnapshot() error {
+	path := snapshotFile(idx.root)
+	if path == "" {
+		return nil
+	}
+
+	idx.mu.RLock()
+	if idx.stale || idx.walker == nil || idx.savedGen == idx.generation {
+		idx.mu.RUnlock()
+		return nil
+	}
+	gen := idx.generation
+	snap := &indexSnapshot{
+		Version:  snapshotVersion,
+		Root:     idx.root,
+		Policy:   idx.policy,
+		MaxFiles: maxFiles,
+		Stats:    idx.stats,
+		BuiltAt:  idx.builtAt,
+		SavedAt:  time.Now(),
+	}
+	idx.mu.RUnlock()
+
+	snap.Files, snap.Meta, _ = idx.Candidates()
+	if err := writeSnapshot(path, snap); err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	idx.savedGen = max(idx.savedGen, gen)
+	idx.mu.Unlock()
+	return nil
+}
+
+// saveSnapshots 保存所有根目录的索引快照
+func saveSnapshots() {
+	for _, root := range listRoots() {
+		idx := lookupIndex(root.Path)
+		if idx == nil {
+			continue
+		}
+		if err := idx.saveSnapshot(); err != nil {
+			log.Printf("保存快照失败 %s: %v", root.Name, err)
+		}
+	}
+}
+
+// saveSnapshotsEvery 定期保存快照
+func saveSnapshotsEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		saveSnapshots()
+	}
+}
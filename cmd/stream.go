@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamEvent 是 NDJSON 流中的一行。SSE 流中 Type 是事件名，data 只包含 Data
+type StreamEvent struct {
+	Type string `json:"type"` // result、summary 或 error
+	Data any    `json:"data"` // result 为 SearchResult，summary 和 error 为 SearchResponse
+}
+
+// streamWriteTimeout 是写入一个事件的最长时间。搜索在发送结果期间持有评分方案，
+// 不读取数据的客户端不能让搜索一直停在写入上
+const streamWriteTimeout = 30 * time.Second
+
+// streamWriter 按 SSE 或 NDJSON 格式写入事件，每个事件写入后立即发送
+type streamWriter struct {
+	w   http.ResponseWriter
+	rc  *http.ResponseController
+	sse bool
+}
+
+// newStreamWriter 根据 format 参数或 Accept 头选择格式并写入响应头
+func newStreamWriter(w http.ResponseWriter, r *http.Request) *streamWriter {
+	format := r.URL.Query().Get("format")
+	s := &streamWriter{
+		w:   w,
+		rc:  http.NewResponseController(w),
+		sse: format == "sse" || format == "" && strings.Contains(r.Header.Get("Accept"), "text/event-stream"),
+	}
+	if s.sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // 避免反向代理缓冲
+	return s
+}
+
+// send 写入一个事件并发送，超过 streamWriteTimeout 时返回错误
+func (s *streamWriter) send(event string, data any) error {
+	var (
+		b   []byte
+		err error
+	)
+	s.rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+	if s.sse {
+		if b, err = json.Marshal(data); err == nil {
+			_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, b)
+		}
+	} else {
+		if b, err = json.Marshal(StreamEvent{Type: event, Data: data}); err == nil {
+			_, err = fmt.Fprintf(s.w, "%s\n", b)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+// fail 以给定的状态码发送一个 error 事件，只能在发送其他事件之前调用
+func (s *streamWriter) fail(status int, msg string) {
+	s.w.WriteHeader(status)
+	s.send("error", SearchResponse{Error: msg})
+}
+
+// handleSearchStream 是 /api/search 的流式版本：fzf 每输出一个结果就发送一个 result 事件，
+// 全部匹配完成后发送 summary 事件，内容与 /api/search 的响应相同，
+// 即排序后的第一页结果、总数、snapshotId、统计和耗时，之后可以用 /api/search 翻页。
+// 请求体与 /api/search 相同；也可以用 GET 和 query、root、sort、desc、limit 参数，供 EventSource 使用
+func handleSearchStream(w http.ResponseWriter, r *http.Request) {
+	s := newStreamWriter(w, r)
+
+	var req SearchRequest
+	switch r.Method {
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			s.fail(http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.Roots = q["root"]
+		req.Sort = q.Get("sort")
+		req.Desc = q.Get("desc") == "true"
+		req.Limit, _ = strconv.Atoi(q.Get("limit"))
+	default:
+		s.fail(http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if _, err := pageLimit(req.Offset, req.Limit); err != nil {
+		s.fail(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 客户端断开、取消请求或写入超时时停止遍历和匹配
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	p, err := req.plan(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		status := http.StatusOK
+		var serr *searchError
+		if errors.As(err, &serr) {
+			status = serr.status
+		}
+		s.fail(status, err.Error())
+		return
+	}
+
+	// 结果按匹配的先后发送，汇总按与 /api/search 相同的顺序排序
+	results, err := streamResults(ctx, p.searcher, req.Query, p.sets, p.opts, func(result SearchResult) {
+		if err := s.send("result", result); err != nil {
+			cancel()
+		}
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		s.send("error", SearchResponse{Error: "搜索失败: " + err.Error()})
+		return
+	}
+
+	sortResults(results, req.Sort, req.Desc)
+
+	resp, err := resultSnapshots.add(newResultSnapshot(p, req.Query, results)).page(req.Offset, req.Limit)
+	if err != nil {
+		s.send("error", SearchResponse{Error: err.Error()})
+		return
+	}
+	resp.fill(req.Verify)
+	s.send("summary", resp)
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/charlievieth/fastwalk"
+)
+
+// maxFiles 是单次遍历收集的最大文件数，0 表示不限制
+var maxFiles int
+
+// errWalkLimit 在达到 maxFiles 时终止遍历
+var errWalkLimit = errors.New("达到最大文件数限制")
+
+// maxWarnings 是单次遍历最多保留的警告条数，超出的只计数
+const maxWarnings = 100
+
+// walkStats 记录一次遍历访问和跳过的情况
+type walkStats struct {
+	Scanned   int      // 访问过的文件和目录数
+	Skipped   int      // 因无法读取而跳过的条目数
+	Warnings  []string // 跳过原因，最多 maxWarnings 条
+	Truncated bool     // 是否因 maxFiles 限制而提前结束
+}
+
+// walkResult 是一次目录遍历的结果
+type walkResult struct {
+	Files []string   // 候选的相对路径，顺序不确定
+	Meta  []fileMeta // 与 Files 一一对应的文件信息
+	walkStats
+}
+
+// skip 记录一个被跳过的条目（无法读取或链接不可跟随），调用方需持有锁
+func (s *walkStats) skip(path string, err error) {
+	s.Skipped++
+	if len(s.Warnings) < maxWarnings {
+		s.Warnings = append(s.Warnings, fmt.Sprintf("跳过 %s: %v", path, err))
+	}
+}
+
+// 候选条目类型
+const (
+	entriesFiles = "files" // 只包含文件（默认）
+	entriesDirs  = "dirs"  // 只包含目录
+	entriesAll   = "all"   // 文件和目录
+)
+
+// WalkPolicy 控制遍历目录时哪些条目成为候选
+type WalkPolicy struct {
+	NoIgnore bool     `json:"noIgnore"` // 不读取 .gitignore/.ignore/.fdignore
+	Hidden   bool     `json:"hidden"`   // 包含隐藏文件和目录
+	Exclude  []string `json:"exclude"`  // 额外排除的通配符，匹配的目录不再进入
+	Include  []string `json:"include"`  // 只保留匹配的候选，为空时保留全部
+	MaxDepth int      `json:"maxDepth"` // 最大遍历深度，0 表示不限制
+	Entries  string   `json:"entries"`  // 候选条目类型: files、dirs 或 all
+
+	Follow        bool `json:"follow"`        // 跟随符号链接
+	FollowOutside bool `json:"followOutside"` // 允许跟随指向根目录之外的符号链接
+}
+
+// defaultPolicy 是索引使用的遍历策略，由命令行参数和配置文件设置
+var defaultPolicy WalkPolicy
+
+// Equal 判断两个策略是否等价
+func (p WalkPolicy) Equal(o WalkPolicy) bool {
+	return p.NoIgnore == o.NoIgnore &&
+		p.Hidden == o.Hidden &&
+		slices.Equal(p.Exclude, o.Exclude) &&
+		slices.Equal(p.Include, o.Include) &&
+		p.MaxDepth == o.MaxDepth &&
+		p.entries() == o.entries() &&
+		p.Follow == o.Follow &&
+		p.FollowOutside == o.FollowOutside
+}
+
+func (p WalkPolicy) entries() string {
+	if p.Entries == "" {
+		return entriesFiles
+	}
+	return p.Entries
+}
+
+// walker 在一个根目录下按 WalkPolicy 遍历，返回相对于根目录的路径
+type walker struct {
+	root     string
+	realRoot string // 解析符号链接后的根目录，用于判断链接是否越界
+	policy   WalkPolicy
+	ignores  *ignoreTree // NoIgnore 时为 nil
+	exclude  []ignorePattern
+	include  []ignorePattern
+	onDir    func(path string)
+}
+
+// newWalker 创建遍历器，onDir 不为 nil 时对每个进入的目录调用，可能被并发调用
+func newWalker(root string, policy WalkPolicy, onDir func(path string)) (*walker, error) {
+	switch policy.entries() {
+	case entriesFiles, entriesDirs, entriesAll:
+	default:
+		return nil, fmt.Errorf("无效的条目类型: %s", policy.Entries)
+	}
+	if policy.MaxDepth < 0 {
+		return nil, fmt.Errorf("无效的最大深度: %d", policy.MaxDepth)
+	}
+
+	w := &walker{
+		root:   filepath.Clean(root),
+		policy: policy,
+		onDir:  onDir,
+	}
+	w.realRoot = realPath(w.root)
+	if !policy.NoIgnore {
+		w.ignores = newIgnoreTree(w.root)
+	}
+
+	var err error
+	if w.exclude, err = compileGlobs(policy.Exclude); err != nil {
+		return nil, err
+	}
+	if w.include, err = compileGlobs(policy.Include); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// compileGlobs 按 gitignore 语法编译通配符，不含 / 的通配符匹配任意层级的名字
+func compileGlobs(globs []string) ([]ignorePattern, error) {
+	var patterns []ignorePattern
+	for _, glob := range globs {
+		p, ok := parseIgnorePattern(glob)
+		if !ok || p.negate {
+			return nil, fmt.Errorf("无效的通配符: %q", glob)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// matchGlobs 判断相对路径是否匹配任一通配符
+func matchGlobs(patterns []ignorePattern, rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	for _, p := range patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkFiles 按策略遍历整个目录，ctx 取消时停止遍历
+func walkFiles(ctx context.Context, dir string, policy WalkPolicy) (*walkResult, error) {
+	w, err := newWalker(dir, policy, nil)
+	if err != nil {
+		return nil, err
+	}
+	return w.walk(ctx, dir)
+}
+
+// walk 使用 fastwalk 并行遍历 dir（根目录或其子目录），返回候选相对于根目录的路径。
+// 无法读取的条目会被跳过并记录在结果中，只有 dir 本身不可访问或 ctx 取消时才返回错误
+func (w *walker) walk(ctx context.Context, dir string) (*walkResult, error) {
+	var (
+		mu      sync.Mutex
+		res     walkResult
+		scanned atomic.Int64
+		guard   *linkGuard
+	)
+	if w.policy.Follow {
+		guard = w.newLinkGuard(dir)
+	}
+
+	// 符号链接由 walkFn 通过 ErrTraverseLink 自行跟随，以便检查循环和越界
+	conf := fastwalk.Config{
+		Follow:     false,
+		NumWorkers: fastwalk.DefaultNumWorkers(),
+	}
+
+	err := fastwalk.Walk(&conf, dir, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			// 读取目录或文件失败时跳过该条目，继续遍历其余部分
+			mu.Lock()
+			res.skip(path, err)
+			mu.Unlock()
+			return nil
+		}
+		scanned.Add(1)
+
+		relPath, err := filepath.Rel(w.root, path)
+		if err != nil {
+			return err
+		}
+
+		// 根目录本身不做过滤，也不作为候选
+		isDir := d.IsDir()
+		if relPath == "." {
+			if w.onDir != nil {
+				w.onDir(path)
+			}
+			return nil
+		}
+
+		// 遍历起点由 fastwalk 解析过链接，需要重新判断它本身是否为符号链接
+		isStart := path == dir
+		isLink := d.Type()&fs.ModeSymlink != 0
+		if isStart {
+			if info, err := os.Lstat(path); err == nil {
+				isLink = info.Mode()&fs.ModeSymlink != 0
+			}
+		}
+
+		follow := false
+		var target fs.FileInfo
+		if isLink && w.policy.Follow {
+			target, err = w.resolveLink(path, guard)
+			if err != nil {
+				mu.Lock()
+				res.skip(path, err)
+				mu.Unlock()
+				if isStart {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			isDir = target.IsDir()
+			follow = isDir
+		} else if isDir && guard != nil {
+			// 记录普通目录，指向它们的链接会被识别为循环
+			if info, err := d.Info(); err == nil {
+				guard.visit(fileIDOf(path, info))
+			}
+		}
+
+		if w.skip(path, relPath, d.Name(), isDir) {
+			if isDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if isDir && w.onDir != nil {
+			w.onDir(path)
+		}
+
+		if w.candidate(relPath, isDir) {
+			// 文件信息在遍历时读取一次，搜索时不再读取
+			info, err := d.Info()
+			mu.Lock()
+			switch {
+			case err != nil:
+				res.skip(path, err)
+			case maxFiles > 0 && len(res.Files) >= maxFiles:
+				// 限制文件数量
+				res.Truncated = true
+				mu.Unlock()
+				return errWalkLimit
+			default:
+				res.Files = append(res.Files, relPath)
+				res.Meta = append(res.Meta, linkMeta(path, info, target))
+			}
+			mu.Unlock()
+		}
+
+		// 达到最大深度的目录不再进入
+		if isDir && w.policy.MaxDepth > 0 && depth(relPath) >= w.policy.MaxDepth {
+			return filepath.SkipDir
+		}
+		if follow && !isStart {
+			return fastwalk.ErrTraverseLink
+		}
+		return nil
+	})
+	if errors.Is(err, errWalkLimit) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res.Scanned = int(scanned.Load())
+	return &res, nil
+}
+
+// fileID 唯一标识一个目录，在 unix 上为设备号和 inode
+type fileID struct {
+	dev, ino uint64
+	path     string
+}
+
+// linkGuard 记录一次遍历中已进入的目录，避免符号链接造成循环或重复遍历
+type linkGuard struct {
+	mu   sync.Mutex
+	seen map[fileID]bool
+}
+
+// newLinkGuard 创建 linkGuard，并记录从根目录到 dir 之间的各级目录
+func (w *walker) newLinkGuard(dir string) *linkGuard {
+	g := &linkGuard{seen: map[fileID]bool{}}
+	for p := dir; ; p = filepath.Dir(p) {
+		// dir 本身可能是待检查的符号链接，不预先记录
+		if p != dir || p == w.root {
+			if info, err := os.Stat(p); err == nil {
+				g.visit(fileIDOf(p, info))
+			}
+		}
+		if p == w.root || !withinDir(w.root, p) {
+			break
+		}
+	}
+	return g
+}
+
+// visit 记录目录，已经记录过时返回 false
+func (g *linkGuard) visit(id fileID) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.seen[id] {
+		return false
+	}
+	g.seen[id] = true
+	return true
+}
+
+// resolveLink 解析符号链接并检查是否可以跟随：
+// 目标必须存在、默认不能位于根目录之外，目录不能已经遍历过
+func (w *walker) resolveLink(path string, guard *linkGuard) (fs.FileInfo, error) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析符号链接: %v", err)
+	}
+	if !w.policy.FollowOutside && !withinDir(w.realRoot, real) {
+		return nil, fmt.Errorf("符号链接指向根目录之外 (%s)，未跟随", real)
+	}
+
+	info, err := os.Stat(real)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() && !guard.visit(fileIDOf(real, info)) {
+		return nil, fmt.Errorf("符号链接指向已遍历的目录 (%s)，可能存在循环，未跟随", real)
+	}
+	return info, nil
+}
+
+// realPath 返回解析符号链接后的路径，解析失败时返回原路径
+func realPath(path string) string {
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	return path
+}
+
+// withinDir 判断 target 是否为 base 本身或位于 base 之下，
+// 按路径分段比较，/data 不会匹配 /data2
+func withinDir(base, target string) bool {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// skip 判断条目是否应被排除，被排除的目录不再进入：
+// 隐藏文件、常见系统目录、超出最大深度、额外排除的通配符以及忽略文件中的规则
+func (w *walker) skip(path, rel, name string, isDir bool) bool {
+	if skipEntry(name, isDir, w.policy.Hidden) {
+		return true
+	}
+	if w.policy.MaxDepth > 0 && depth(rel) > w.policy.MaxDepth {
+		return true
+	}
+	if matchGlobs(w.exclude, rel, isDir) {
+		return true
+	}
+	return w.ignores != nil && w.ignores.Ignored(path, isDir)
+}
+
+// candidate 判断未被排除的条目是否作为搜索候选
+func (w *walker) candidate(rel string, isDir bool) bool {
+	switch w.policy.entries() {
+	case entriesFiles:
+		if isDir {
+			return false
+		}
+	case entriesDirs:
+		if !isDir {
+			return false
+		}
+	}
+	return len(w.include) == 0 || matchGlobs(w.include, rel, isDir)
+}
+
+// accept 判断单个条目是否属于索引，用于处理文件变化事件
+func (w *walker) accept(path, rel string, isDir bool) bool {
+	if w.policy.Follow && !w.policy.FollowOutside && !withinDir(w.realRoot, realPath(path)) {
+		return false
+	}
+	return !w.skip(path, rel, filepath.Base(path), isDir) && w.candidate(rel, isDir)
+}
+
+// depth 返回相对路径的层级，根目录下的条目为 1
+func depth(rel string) int {
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
+
+// skipEntry 判断文件或目录是否应当跳过，hidden 为 true 时保留隐藏条目
+func skipEntry(name string, isDir bool, hidden bool) bool {
+	// 跳过隐藏文件和目录
+	if !hidden && strings.HasPrefix(name, ".") {
+		return true
+	}
+
+	// 跳过一些常见的系统目录
+	if isDir {
+		return name == "node_modules" || name == ".git" || name == "__pycache__"
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWalkSymlinks(t *testing.T) {
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, base, "root/a.txt", "root/dir/b.txt", "outside/o.txt")
+	root := filepath.Join(base, "root")
+	link := func(target, rel string) { symlink(t, target, filepath.Join(root, rel)) }
+	link("a.txt", "link.txt")
+	link("..", "dir/loop")                                   // 指回根目录
+	link(".", "dir/self")                                    // 指向所在目录
+	link(filepath.Join(base, "outside"), "out")              // 根目录之外的目录
+	link(filepath.Join(base, "outside", "o.txt"), "outfile") // 根目录之外的文件
+	link("missing", "broken")
+
+	tests := []struct {
+		name    string
+		policy  WalkPolicy
+		want    []string
+		links   []string          // 期望标记为符号链接的候选
+		skipped map[string]string // 期望跳过的条目及原因中的文字
+	}{
+		{
+			name:   "不跟随",
+			policy: WalkPolicy{},
+			want:   []string{"a.txt", "broken", "dir/b.txt", "dir/loop", "dir/self", "link.txt", "out", "outfile"},
+			links:  []string{"broken", "dir/loop", "dir/self", "link.txt", "out", "outfile"},
+		},
+		{
+			name:   "跟随",
+			policy: WalkPolicy{Follow: true},
+			want:   []string{"a.txt", "dir/b.txt", "link.txt"},
+			links:  []string{"link.txt"},
+			skipped: map[string]string{
+				"dir/loop": "循环",
+				"dir/self": "循环",
+				"out":      "根目录之外",
+				"outfile":  "根目录之外",
+				"broken":   "无法解析",
+			},
+		},
+		{
+			name:   "跟随到根目录之外",
+			policy: WalkPolicy{Follow: true, FollowOutside: true},
+			want:   []string{"a.txt", "dir/b.txt", "link.txt", "out/o.txt", "outfile"},
+			links:  []string{"link.txt", "outfile"},
+			skipped: map[string]string{
+				"dir/loop": "循环",
+				"dir/self": "循环",
+				"broken":   "无法解析",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := walkFiles(context.Background(), root, tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.sort()
+			if !slices.Equal(res.Files, tt.want) {
+				t.Fatalf("候选 = %q，期望 %q", res.Files, tt.want)
+			}
+			for i, rel := range res.Files {
+				if got, want := res.Meta[i].Symlink, slices.Contains(tt.links, rel); got != want {
+					t.Errorf("%s 的 Symlink = %v，期望 %v", rel, got, want)
+				}
+			}
+			// writeFiles 以相对路径作为文件内容
+			if i := slices.Index(res.Files, "link.txt"); res.Meta[i].Size != int64(len("root/a.txt")) {
+				t.Errorf("link.txt 的大小 = %d，期望链接目标的大小", res.Meta[i].Size)
+			}
+
+			if res.Skipped != len(tt.skipped) {
+				t.Errorf("跳过 %d 个条目，期望 %d 个: %q", res.Skipped, len(tt.skipped), res.Warnings)
+			}
+			for rel, reason := range tt.skipped {
+				prefix := "跳过 " + filepath.Join(root, rel) + ":"
+				if !slices.ContainsFunc(res.Warnings, func(w string) bool {
+					return strings.HasPrefix(w, prefix) && strings.Contains(w, reason)
+				}) {
+					t.Errorf("没有因%s跳过 %s 的警告: %q", reason, rel, res.Warnings)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root 用户可以读取任何目录")
+	}
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, root, "a.txt", "locked/b.txt", "open/c.txt")
+	locked := filepath.Join(root, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(locked, 0o755) })
+
+	// 无法读取的目录被跳过并记录警告，其余部分照常遍历
+	res, err := walkFiles(context.Background(), root, WalkPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.sort()
+	if want := []string{"a.txt", "open/c.txt"}; !slices.Equal(res.Files, want) {
+		t.Errorf("候选 = %q，期望 %q", res.Files, want)
+	}
+	if res.Skipped != 1 || len(res.Warnings) != 1 || !strings.HasPrefix(res.Warnings[0], "跳过 "+locked+":") {
+		t.Errorf("跳过 %d 个条目，警告 %q，期望只跳过 %s", res.Skipped, res.Warnings, locked)
+	}
+
+	// 遍历的起点本身无法读取时返回错误
+	if _, err := walkFiles(context.Background(), locked, WalkPolicy{}); err == nil {
+		t.Errorf("遍历无法读取的起点应返回错误")
+	}
+}